@@ -0,0 +1,276 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/progress"
+)
+
+var ErrBackupExists = errors.New("backup destination already exists")
+
+const backupProgressInterval = 250 * time.Millisecond
+
+// Backup writes a consistent snapshot of the database to the given path while the database stays in use.
+//
+// On SQLite, the backup is a regular SQLite database file created using VACUUM INTO.
+//
+// On Postgres, the backup is a SQL script containing the data of all tables in the current schema,
+// including the version table, but not other version tracking tables like database_owner or the version
+// tables of child databases. The script doesn't contain the schema itself: to restore it, upgrade an
+// empty database to the same version and then run the script, which replaces all existing rows.
+//
+// The backup is first written and synced to a temporary file in the same directory as the destination,
+// which is linked into place once the backup is complete (or renamed, on filesystems that don't support
+// hard links), so the destination never contains a partial backup. If the destination already exists,
+// [ErrBackupExists] is returned and the existing file is left as-is. The optional progress function is
+// called periodically with the number of bytes written so far, like the callback of [progress.Writer].
+func (db *Database) Backup(ctx context.Context, destPath string, progressFn func(processedBytes int)) error {
+	if db.Dialect != SQLite && db.Dialect != Postgres {
+		return ErrUnsupportedDialect
+	} else if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrBackupExists, destPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check backup destination: %w", err)
+	}
+	if progressFn == nil {
+		progressFn = func(int) {}
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary backup file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		_ = os.Remove(tempPath)
+	}()
+	if db.Dialect == SQLite {
+		// VACUUM INTO opens the file by itself, which is allowed as long as the file is empty
+		_ = tempFile.Close()
+		err = db.backupSQLite(ctx, tempPath, progressFn)
+	} else {
+		err = db.backupPostgres(ctx, tempFile, progressFn)
+	}
+	if err != nil {
+		return err
+	}
+	if err = moveBackup(tempPath, destPath); errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrBackupExists, destPath)
+	} else if err != nil {
+		return fmt.Errorf("failed to move backup to destination: %w", err)
+	}
+	return nil
+}
+
+// linkFile is os.Link, but it can be replaced in tests to simulate filesystems without hard links.
+var linkFile = os.Link
+
+// moveBackup moves the finished backup to the destination without overwriting any existing file.
+// The caller is responsible for removing the temporary file afterwards.
+func moveBackup(tempPath, destPath string) error {
+	// Unlike renaming, linking fails if the destination was created while the backup was running
+	err := linkFile(tempPath, destPath)
+	if !errors.Is(err, errors.ErrUnsupported) && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	// Some filesystems don't support hard links, so fall back to claiming the destination
+	// with an exclusive create and then replacing the empty placeholder by renaming.
+	placeholder, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_ = placeholder.Close()
+	if err = os.Rename(tempPath, destPath); err != nil {
+		_ = os.Remove(destPath)
+		return err
+	}
+	return nil
+}
+
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (db *Database) backupSQLite(ctx context.Context, path string, progressFn func(processedBytes int)) error {
+	// VACUUM INTO can't report progress by itself, so watch the size of the output file instead.
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		ticker := time.NewTicker(backupProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if info, err := os.Stat(path); err == nil {
+					progressFn(int(info.Size()))
+				}
+			}
+		}
+	}()
+	_, err := db.Exec(ctx, "VACUUM INTO $1", path)
+	close(done)
+	<-watcherDone
+	if err != nil {
+		return fmt.Errorf("failed to vacuum into backup file: %w", err)
+	}
+	// VACUUM INTO doesn't necessarily sync the output file, so do it manually to make sure the backup survives a crash
+	if err = syncFile(path); err != nil {
+		return fmt.Errorf("failed to sync backup file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat backup file: %w", err)
+	}
+	progressFn(int(info.Size()))
+	return nil
+}
+
+func (db *Database) backupPostgres(ctx context.Context, file *os.File, progressFn func(processedBytes int)) error {
+	buf := bufio.NewWriter(io.MultiWriter(file, progress.NewWriter(progressFn)))
+	// Repeatable read makes all queries in the transaction see the same snapshot
+	err := db.DoTxn(ctx, &TxnOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(ctx context.Context) error {
+		return db.writePostgresBackup(ctx, buf)
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	} else if closeErr != nil {
+		return fmt.Errorf("failed to close backup file: %w", closeErr)
+	}
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return fmt.Errorf("failed to stat backup file: %w", err)
+	}
+	progressFn(int(info.Size()))
+	return nil
+}
+
+func (db *Database) writePostgresBackup(ctx context.Context, w *bufio.Writer) error {
+	schema, err := db.DumpSchema(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to dump schema: %w", err)
+	}
+	// The version table is included so the restored data can be matched with the right schema version,
+	// but the other version tracking tables belong to the database being restored into.
+	skip := slices.DeleteFunc(db.versionTrackingTables(schema), func(name string) bool {
+		return name == db.VersionTable
+	})
+	tables, err := copyOrder(schema, skip)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "-- dbutil logical backup created at %s\n", time.Now().UTC().Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "-- version table: %s\n\nBEGIN;\n\n", db.VersionTable)
+	for _, table := range slices.Backward(tables) {
+		_, _ = fmt.Fprintf(w, "DELETE FROM %s;\n", quoteIdentifiers([]string{table.Name})[0])
+	}
+	for _, table := range tables {
+		if err = db.writePostgresTableBackup(ctx, w, table); err != nil {
+			return fmt.Errorf("failed to back up table %s: %w", table.Name, err)
+		}
+	}
+	_, err = w.WriteString("\nCOMMIT;\n")
+	return err
+}
+
+func (db *Database) writePostgresTableBackup(ctx context.Context, w *bufio.Writer, table *SchemaTable) error {
+	columnNames := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		columnNames[i] = col.Name
+	}
+	quotedTable := quoteIdentifiers([]string{table.Name})[0]
+	quotedColumns := quoteIdentifiers(columnNames)
+	// Casting everything to text makes Postgres do the formatting, and the text
+	// form is accepted as an untyped literal when inserting the value back.
+	selectColumns := make([]string, len(quotedColumns))
+	for i, col := range quotedColumns {
+		selectColumns[i] = col + "::text"
+	}
+	rows, err := db.Query(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectColumns, ", "), quotedTable))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	_, _ = fmt.Fprintf(w, "\n-- %s\n", table.Name)
+	// OVERRIDING SYSTEM VALUE allows restoring GENERATED ALWAYS identity columns and is a no-op for other tables.
+	insertPrefix := fmt.Sprintf(
+		"INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE VALUES (", quotedTable, strings.Join(quotedColumns, ", "),
+	)
+	values := make([]sql.NullString, len(columnNames))
+	scanTargets := make([]any, len(values))
+	for i := range values {
+		scanTargets[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(scanTargets...); err != nil {
+			return err
+		}
+		_, _ = w.WriteString(insertPrefix)
+		for i, val := range values {
+			if i > 0 {
+				_, _ = w.WriteString(", ")
+			}
+			_, _ = w.WriteString(postgresLiteral(val))
+		}
+		_, err = w.WriteString(");\n")
+		if err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, col := range table.Columns {
+		if col.Type != "integer" {
+			continue
+		}
+		// setval is strict, so this does nothing for columns without a sequence.
+		quotedColumn := quoteIdentifiers([]string{col.Name})[0]
+		_, _ = fmt.Fprintf(
+			w, "SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false);\n",
+			postgresLiteral(sql.NullString{String: quotedTable, Valid: true}),
+			postgresLiteral(sql.NullString{String: col.Name, Valid: true}),
+			quotedColumn, quotedTable,
+		)
+	}
+	return nil
+}
+
+func postgresLiteral(val sql.NullString) string {
+	if !val.Valid {
+		return "NULL"
+	}
+	return "'" + strings.ReplaceAll(val.String, "'", "''") + "'"
+}
@@ -0,0 +1,169 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Backup_SQLite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewWithDialect("file:"+filepath.Join(dir, "main.db")+"?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY, value TEXT NOT NULL)")
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "INSERT INTO foo (value) VALUES ($1), ($2)", "meow", strings.Repeat("a", 100000))
+	require.NoError(t, err)
+
+	backupPath := filepath.Join(dir, "backup.db")
+	var lastProgress int
+	require.NoError(t, db.Backup(ctx, backupPath, func(processedBytes int) {
+		lastProgress = processedBytes
+	}))
+	assert.Greater(t, lastProgress, 100000)
+	tempFiles, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+
+	err = db.Backup(ctx, backupPath, nil)
+	assert.ErrorIs(t, err, ErrBackupExists)
+
+	// A destination that appears while the backup is running must not be overwritten
+	racePath := filepath.Join(dir, "race.db")
+	err = db.Backup(ctx, racePath, func(processedBytes int) {
+		if processedBytes > 100000 {
+			_ = os.WriteFile(racePath, []byte("meow"), 0600)
+		}
+	})
+	assert.ErrorIs(t, err, ErrBackupExists)
+	data, err := os.ReadFile(racePath)
+	require.NoError(t, err)
+	assert.Equal(t, "meow", string(data))
+	tempFiles, err = filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+
+	backup, err := NewWithDialect(backupPath, "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer backup.Close()
+	var count int
+	require.NoError(t, backup.QueryRow(ctx, "SELECT COUNT(*) FROM foo").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestDatabase_Backup_NoHardLinks(t *testing.T) {
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	t.Cleanup(func() {
+		linkFile = os.Link
+	})
+	dir := t.TempDir()
+	db, err := NewWithDialect("file:"+filepath.Join(dir, "main.db")+"?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY, value TEXT NOT NULL); INSERT INTO foo (value) VALUES ('meow')")
+	require.NoError(t, err)
+
+	backupPath := filepath.Join(dir, "backup.db")
+	require.NoError(t, db.Backup(ctx, backupPath, nil))
+	backup, err := NewWithDialect(backupPath, "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer backup.Close()
+	var value string
+	require.NoError(t, backup.QueryRow(ctx, "SELECT value FROM foo").Scan(&value))
+	assert.Equal(t, "meow", value)
+
+	// The fallback must not overwrite a destination that appears while the backup is running either
+	racePath := filepath.Join(dir, "race.db")
+	err = db.Backup(ctx, racePath, func(processedBytes int) {
+		if processedBytes > 0 {
+			_ = os.WriteFile(racePath, []byte("meow"), 0600)
+		}
+	})
+	assert.ErrorIs(t, err, ErrBackupExists)
+	data, err := os.ReadFile(racePath)
+	require.NoError(t, err)
+	assert.Equal(t, "meow", string(data))
+	tempFiles, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+}
+
+func TestDatabase_Backup_Postgres(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	db := &Database{
+		RawDB:        conn,
+		Log:          NoopLogger,
+		VersionTable: "version",
+		Dialect:      Postgres,
+		txnCtxKey:    contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+	}
+	db.LoggingDB.UnderlyingExecable = conn
+	db.LoggingDB.db = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(listTablesPostgres).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("version").AddRow("database_owner").AddRow("room"))
+	expectPostgresTableDump(mock, "database_owner", [][]driver.Value{{"key", "integer", true}, {"owner", "text", true}}, nil)
+	expectPostgresTableDump(mock, "room", [][]driver.Value{
+		{"id", "bigint", true}, {"name", "text", false},
+	}, [][]driver.Value{{"p", "id", "", "", "a", "a"}})
+	expectPostgresTableDump(mock, "version", [][]driver.Value{{"version", "integer", false}, {"compat", "integer", false}}, nil)
+	mock.ExpectQuery(`SELECT "id"::text, "name"::text FROM "room"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "it's").AddRow("2", nil))
+	mock.ExpectQuery(`SELECT "version"::text, "compat"::text FROM "version"`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "compat"}).AddRow("3", "2"))
+	mock.ExpectCommit()
+
+	backupPath := filepath.Join(t.TempDir(), "backup.sql")
+	var lastProgress int
+	require.NoError(t, db.Backup(context.Background(), backupPath, func(processedBytes int) {
+		lastProgress = processedBytes
+	}))
+	require.NoError(t, mock.ExpectationsWereMet())
+	data, err := os.ReadFile(backupPath)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), lastProgress)
+	header, script, ok := strings.Cut(string(data), "\n")
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(header, "-- dbutil logical backup created at "))
+	assert.Equal(t, `-- version table: version
+
+BEGIN;
+
+DELETE FROM "version";
+DELETE FROM "room";
+
+-- room
+INSERT INTO "room" ("id", "name") OVERRIDING SYSTEM VALUE VALUES ('1', 'it''s');
+INSERT INTO "room" ("id", "name") OVERRIDING SYSTEM VALUE VALUES ('2', NULL);
+SELECT setval(pg_get_serial_sequence('"room"', 'id'), COALESCE((SELECT MAX("id") FROM "room"), 0) + 1, false);
+
+-- version
+INSERT INTO "version" ("version", "compat") OVERRIDING SYSTEM VALUE VALUES ('3', '2');
+SELECT setval(pg_get_serial_sequence('"version"', 'version'), COALESCE((SELECT MAX("version") FROM "version"), 0) + 1, false);
+SELECT setval(pg_get_serial_sequence('"version"', 'compat'), COALESCE((SELECT MAX("compat") FROM "version"), 0) + 1, false);
+
+COMMIT;
+`, script)
+}
+
+func TestPostgresLiteral(t *testing.T) {
+	assert.Equal(t, "NULL", postgresLiteral(sql.NullString{}))
+	assert.Equal(t, "''", postgresLiteral(sql.NullString{Valid: true}))
+	assert.Equal(t, `'it''s \x00'`, postgresLiteral(sql.NullString{String: `it's \x00`, Valid: true}))
+}
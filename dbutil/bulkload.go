@@ -0,0 +1,140 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BulkLoader inserts large amounts of rows into a single table.
+//
+// On Postgres, rows are inserted using `COPY ... FROM STDIN` if a [CopyFunc] has been registered for the
+// driver, which is much faster than multi-row INSERTs. The pqcopy and pgxcopy subpackages register one for
+// lib/pq and pgx respectively. With other drivers (including SQLite), or if the copy function returns
+// [ErrCopyUnavailable], it falls back to chunked INSERTs using [MassInsertBuilder.Exec].
+// In all cases, the rows are inserted atomically.
+//
+// Unlike MassInsertBuilder, the bulk loader doesn't support static parameters, upserts or RETURNING,
+// because COPY can only insert plain rows.
+type BulkLoader[Item MassInsertable[DynamicParams], DynamicParams Array] struct {
+	table     string
+	columns   []string
+	copyQuery string
+	fallback  *MassInsertBuilder[Item, [0]any, DynamicParams]
+}
+
+// NewBulkLoader creates a new BulkLoader for the given table and columns.
+// The number of columns must match the size of the DynamicParams array,
+// otherwise this function will panic.
+func NewBulkLoader[Item MassInsertable[DynamicParams], DynamicParams Array](table string, columns ...string) *BulkLoader[Item, DynamicParams] {
+	var dyn DynamicParams
+	if len(columns) != len(dyn) {
+		panic(fmt.Errorf("invalid bulk loader: got %d columns, but items have %d values", len(columns), len(dyn)))
+	}
+	quotedTable := quoteIdentifiers([]string{table})[0]
+	quotedColumns := strings.Join(quoteIdentifiers(columns), ", ")
+	singlePlaceholders := make([]string, len(columns))
+	for i := range singlePlaceholders {
+		singlePlaceholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return &BulkLoader[Item, DynamicParams]{
+		table:     table,
+		columns:   columns,
+		copyQuery: fmt.Sprintf("COPY %s (%s) FROM STDIN", quotedTable, quotedColumns),
+		fallback: NewMassInsertBuilder[Item, [0]any](
+			fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quotedTable, quotedColumns, strings.Join(singlePlaceholders, ", ")),
+			"("+strings.TrimSuffix(strings.Repeat("$%d, ", len(columns)), ", ")+")",
+		),
+	}
+}
+
+// ErrCopyUnavailable can be returned by a [CopyFunc] to make the bulk loader fall back to INSERTs,
+// e.g. if the driver can't use COPY inside a transaction. It must be returned before reading any rows.
+var ErrCopyUnavailable = errors.New("copy is not available in this context")
+
+// CopyFunc inserts rows into a table using the COPY protocol of a specific database driver.
+//
+// The next function returns the values of the next row, or nil after the last row. The returned slice is
+// reused between calls, so it must not be retained. All rows must be inserted atomically, and the transaction in the context (if any) must be respected.
+type CopyFunc func(ctx context.Context, db *Database, table string, columns []string, next func() []any) error
+
+var (
+	copyFuncs     = make(map[reflect.Type]CopyFunc)
+	copyFuncsLock sync.RWMutex
+)
+
+// RegisterCopyFunc registers the function that [BulkLoader] uses to COPY rows on databases using the given driver.
+// This is meant to be called from the init function of driver-specific packages, like the pqcopy and pgxcopy
+// subpackages, so that dbutil itself doesn't need to import any Postgres drivers.
+func RegisterCopyFunc(drv driver.Driver, fn CopyFunc) {
+	copyFuncsLock.Lock()
+	copyFuncs[reflect.TypeOf(drv)] = fn
+	copyFuncsLock.Unlock()
+}
+
+func (db *Database) copyFunc() CopyFunc {
+	if db.Dialect != Postgres {
+		return nil
+	}
+	copyFuncsLock.RLock()
+	defer copyFuncsLock.RUnlock()
+	return copyFuncs[reflect.TypeOf(db.RawDB.Driver())]
+}
+
+// SupportsCopy returns true if a [CopyFunc] has been registered for the driver of the database.
+func (db *Database) SupportsCopy() bool {
+	return db.copyFunc() != nil
+}
+
+// Load inserts all the given items into the database.
+func (bl *BulkLoader[Item, DynamicParams]) Load(ctx context.Context, db *Database, data []Item) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if copyFn := db.copyFunc(); copyFn != nil {
+		i := 0
+		var values DynamicParams
+		row := make([]any, len(values))
+		next := func() []any {
+			if i >= len(data) {
+				return nil
+			}
+			values = data[i].GetMassInsertValues()
+			i++
+			for j := 0; j < len(values); j++ {
+				row[j] = values[j]
+			}
+			return row
+		}
+		err := bl.timeCopy(ctx, db, len(data), func() error {
+			return copyFn(ctx, db, bl.table, bl.columns, next)
+		})
+		if !errors.Is(err, ErrCopyUnavailable) {
+			return err
+		}
+	}
+	return bl.fallback.Exec(ctx, db, [0]any{}, data)
+}
+
+func (bl *BulkLoader[Item, DynamicParams]) timeCopy(ctx context.Context, db *Database, rows int, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	if errors.Is(err, ErrCopyUnavailable) {
+		return err
+	}
+	duration := time.Since(start)
+	db.Log.QueryTiming(ctx, "Copy", bl.copyQuery, nil, rows, duration, err)
+	db.observeQuery(ctx, "Copy", bl.copyQuery, rows, duration, err)
+	return err
+}
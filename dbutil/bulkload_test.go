@@ -0,0 +1,87 @@
+package dbutil
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bulkLoadTestRow struct {
+	ID    int
+	Value string
+}
+
+func (r *bulkLoadTestRow) GetMassInsertValues() [2]any {
+	return [2]any{r.ID, r.Value}
+}
+
+func TestBulkLoader(t *testing.T) {
+	assert.Panics(t, func() {
+		NewBulkLoader[*bulkLoadTestRow]("foo", "id")
+	})
+	loader := NewBulkLoader[*bulkLoadTestRow]("foo", "id", "value")
+	assert.Equal(t, `COPY "foo" ("id", "value") FROM STDIN`, loader.copyQuery)
+
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	assert.False(t, db.SupportsCopy())
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY, value TEXT NOT NULL)")
+	require.NoError(t, err)
+	data := make([]*bulkLoadTestRow, 50000)
+	for i := range data {
+		data[i] = &bulkLoadTestRow{ID: i, Value: "meow"}
+	}
+	require.NoError(t, loader.Load(ctx, db, data))
+	var count int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM foo").Scan(&count))
+	assert.Equal(t, len(data), count)
+}
+
+func TestBulkLoader_CopyFunc(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	db, err := NewWithDB(conn, "postgres")
+	require.NoError(t, err)
+	assert.False(t, db.SupportsCopy())
+
+	var copied [][]any
+	unavailable := false
+	RegisterCopyFunc(conn.Driver(), func(ctx context.Context, db *Database, table string, columns []string, next func() []any) error {
+		if unavailable {
+			return ErrCopyUnavailable
+		}
+		assert.Equal(t, "foo", table)
+		assert.Equal(t, []string{"id", "value"}, columns)
+		for row := next(); row != nil; row = next() {
+			copied = append(copied, slices.Clone(row))
+		}
+		return nil
+	})
+	t.Cleanup(func() {
+		copyFuncsLock.Lock()
+		delete(copyFuncs, reflect.TypeOf(conn.Driver()))
+		copyFuncsLock.Unlock()
+	})
+	require.True(t, db.SupportsCopy())
+
+	loader := NewBulkLoader[*bulkLoadTestRow]("foo", "id", "value")
+	ctx := context.Background()
+	require.NoError(t, loader.Load(ctx, db, []*bulkLoadTestRow{{ID: 1, Value: "meow"}, {ID: 2, Value: "hmm"}}))
+	assert.Equal(t, [][]any{{1, "meow"}, {2, "hmm"}}, copied)
+
+	unavailable = true
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "foo" ("id", "value") VALUES ($1, $2)`).
+		WithArgs(3, "meow").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, loader.Load(ctx, db, []*bulkLoadTestRow{{ID: 3, Value: "meow"}}))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
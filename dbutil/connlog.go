@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +22,8 @@ import (
 type LoggingExecable struct {
 	UnderlyingExecable UnderlyingExecable
 	db                 *Database
+	// baseDB is the pool that a transaction was started from, used for the statement cache.
+	baseDB *sql.DB
 }
 
 type pqError interface {
@@ -66,19 +69,37 @@ func addErrorLine(query string, err error) error {
 
 func (le *LoggingExecable) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
 	start := time.Now()
-	query = le.db.mutateQuery(query)
-	res, err := le.UnderlyingExecable.ExecContext(ctx, query, args...)
+	stmt, query, release := le.cachedStmt(ctx, query, args)
+	var res sql.Result
+	var err error
+	if stmt != nil {
+		res, err = stmt.ExecContext(ctx, args...)
+		release()
+	} else {
+		res, err = le.UnderlyingExecable.ExecContext(ctx, query, args...)
+	}
 	err = addErrorLine(query, err)
-	le.db.Log.QueryTiming(ctx, "Exec", query, args, -1, time.Since(start), err)
+	duration := time.Since(start)
+	le.db.Log.QueryTiming(ctx, "Exec", query, args, -1, duration, err)
+	le.db.observeQuery(ctx, "Exec", query, -1, duration, err)
 	return res, err
 }
 
 func (le *LoggingExecable) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
 	start := time.Now()
-	query = le.db.mutateQuery(query)
-	rows, err := le.UnderlyingExecable.QueryContext(ctx, query, args...)
+	stmt, query, release := le.cachedStmt(ctx, query, args)
+	var rows *sql.Rows
+	var err error
+	if stmt != nil {
+		rows, err = stmt.QueryContext(ctx, args...)
+		release()
+	} else {
+		rows, err = le.UnderlyingExecable.QueryContext(ctx, query, args...)
+	}
 	err = addErrorLine(query, err)
-	le.db.Log.QueryTiming(ctx, "Query", query, args, -1, time.Since(start), err)
+	duration := time.Since(start)
+	le.db.Log.QueryTiming(ctx, "Query", query, args, -1, duration, err)
+	le.db.observeQuery(ctx, "Query", query, -1, duration, err)
 	return &LoggingRows{
 		ctx:   ctx,
 		db:    le.db,
@@ -91,9 +112,17 @@ func (le *LoggingExecable) QueryContext(ctx context.Context, query string, args
 
 func (le *LoggingExecable) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
 	start := time.Now()
-	query = le.db.mutateQuery(query)
-	row := le.UnderlyingExecable.QueryRowContext(ctx, query, args...)
-	le.db.Log.QueryTiming(ctx, "QueryRow", query, args, -1, time.Since(start), nil)
+	stmt, query, release := le.cachedStmt(ctx, query, args)
+	var row *sql.Row
+	if stmt != nil {
+		row = stmt.QueryRowContext(ctx, args...)
+		release()
+	} else {
+		row = le.UnderlyingExecable.QueryRowContext(ctx, query, args...)
+	}
+	duration := time.Since(start)
+	le.db.Log.QueryTiming(ctx, "QueryRow", query, args, -1, duration, nil)
+	le.db.observeQuery(ctx, "QueryRow", query, -1, duration, nil)
 	return row
 }
 
@@ -122,6 +151,14 @@ type TxnOptions struct {
 	ReadOnly   bool
 	Conn       Conn
 	RetryBegin func(error, int) bool
+	// Retry makes [Database.DoTxn] re-run the whole transaction if it fails with a transient error.
+	// It has no effect on nested DoTxn calls, as those are part of the outer transaction.
+	Retry *TxnRetryPolicy
+	// Nested makes [Database.DoTxn] use a savepoint if the context already has a transaction,
+	// so that an error returned by the inner function only rolls back the changes made by it,
+	// instead of the entire outer transaction. Without this, nested DoTxn calls just call the
+	// function directly.
+	Nested bool
 }
 
 func (ld *loggingDB) BeginTx(ctx context.Context, opts *TxnOptions) (*LoggingTxn, error) {
@@ -133,6 +170,7 @@ func (ld *loggingDB) BeginTx(ctx context.Context, opts *TxnOptions) (*LoggingTxn
 		ReadOnly:  opts.ReadOnly,
 	}
 	var tx *sql.Tx
+	var baseDB *sql.DB
 	var err error
 	start := time.Now()
 	for i := 0; ; i++ {
@@ -144,17 +182,20 @@ func (ld *loggingDB) BeginTx(ctx context.Context, opts *TxnOptions) (*LoggingTxn
 				targetDB = ld.db.ReadOnlyDB
 			}
 			tx, err = targetDB.BeginTx(ctx, sqlOpts)
+			baseDB = targetDB
 		}
 		if opts.RetryBegin == nil || err == nil || !opts.RetryBegin(err, i) {
 			break
 		}
 	}
-	ld.db.Log.QueryTiming(ctx, "Begin", "", nil, -1, time.Since(start), err)
+	duration := time.Since(start)
+	ld.db.Log.QueryTiming(ctx, "Begin", "", nil, -1, duration, err)
+	ld.db.observeQuery(ctx, "Begin", "", -1, duration, err)
 	if err != nil {
 		return nil, err
 	}
 	return &LoggingTxn{
-		LoggingExecable: LoggingExecable{UnderlyingExecable: tx, db: ld.db},
+		LoggingExecable: LoggingExecable{UnderlyingExecable: tx, db: ld.db, baseDB: baseDB},
 		UnderlyingTx:    tx,
 		ctx:             ctx,
 		StartTime:       start,
@@ -169,6 +210,9 @@ type LoggingTxn struct {
 	StartTime  time.Time
 	EndTime    time.Time
 	noTotalLog bool
+
+	savepointCounter atomic.Uint64
+	hooks            txnHooks
 }
 
 func (lt *LoggingTxn) Commit() error {
@@ -178,7 +222,11 @@ func (lt *LoggingTxn) Commit() error {
 	if !lt.noTotalLog {
 		lt.db.Log.QueryTiming(lt.ctx, "<Transaction>", "", nil, -1, lt.EndTime.Sub(lt.StartTime), nil)
 	}
-	lt.db.Log.QueryTiming(lt.ctx, "Commit", "", nil, -1, time.Since(start), err)
+	duration := time.Since(start)
+	lt.db.Log.QueryTiming(lt.ctx, "Commit", "", nil, -1, duration, err)
+	lt.db.observeQuery(lt.ctx, "Commit", "", -1, duration, err)
+	lt.db.observeTransaction(lt.ctx, lt.EndTime.Sub(lt.StartTime), err == nil)
+	lt.hooks.finish(err == nil)
 	return err
 }
 
@@ -189,7 +237,11 @@ func (lt *LoggingTxn) Rollback() error {
 	if !lt.noTotalLog {
 		lt.db.Log.QueryTiming(lt.ctx, "<Transaction>", "", nil, -1, lt.EndTime.Sub(lt.StartTime), nil)
 	}
-	lt.db.Log.QueryTiming(lt.ctx, "Rollback", "", nil, -1, time.Since(start), err)
+	duration := time.Since(start)
+	lt.db.Log.QueryTiming(lt.ctx, "Rollback", "", nil, -1, duration, err)
+	lt.db.observeQuery(lt.ctx, "Rollback", "", -1, duration, err)
+	lt.db.observeTransaction(lt.ctx, lt.EndTime.Sub(lt.StartTime), false)
+	lt.hooks.finish(false)
 	return err
 }
 
@@ -205,7 +257,9 @@ type LoggingRows struct {
 
 func (lrs *LoggingRows) stopTiming() {
 	if !lrs.start.IsZero() {
-		lrs.db.Log.QueryTiming(lrs.ctx, "EndRows", lrs.query, lrs.args, lrs.nrows, time.Since(lrs.start), lrs.rs.Err())
+		duration, err := time.Since(lrs.start), lrs.rs.Err()
+		lrs.db.Log.QueryTiming(lrs.ctx, "EndRows", lrs.query, lrs.args, lrs.nrows, duration, err)
+		lrs.db.observeQuery(lrs.ctx, "EndRows", lrs.query, lrs.nrows, duration, err)
 		lrs.start = time.Time{}
 	}
 }
@@ -0,0 +1,309 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrCopyVersionMismatch  = errors.New("source and target databases are at different schema versions")
+	ErrCopySchemaMismatch   = errors.New("source and target database schemas don't match")
+	ErrCopyTargetNotEmpty   = errors.New("target table is not empty")
+	ErrCopyRowCountMismatch = errors.New("row count mismatch after copying")
+	ErrForeignKeyCycle      = errors.New("foreign key cycle between tables")
+)
+
+// CopyOptions contains options for [CopyDatabase].
+type CopyOptions struct {
+	// BatchSize is the maximum number of rows to insert with a single query. Defaults to 1000.
+	// It is lowered automatically if the batch would exceed the parameter limit of the target database.
+	BatchSize int
+	// SkipTables is a list of tables that shouldn't be copied.
+	SkipTables []string
+	// Progress is called after each inserted batch with the number of rows copied from the table so far.
+	Progress func(table string, copied, total int)
+}
+
+const defaultCopyBatchSize = 1000
+
+// CopyDatabase copies all rows from one database to another, e.g. to migrate from SQLite to Postgres.
+//
+// Both databases must already be upgraded to the same schema version, and all tables in the target
+// database must be empty. Tables are copied in an order where referenced tables come before the
+// tables referencing them, so foreign key constraints don't need to be disabled. Version tracking
+// tables (database_owner, the upgrade history table and all version tables, including those of child
+// databases) are not copied.
+//
+// Everything is inserted in a single transaction on the target database, so a failed copy won't
+// leave partial data behind. After all tables are copied, Postgres sequences are moved past the
+// copied values and the row counts of each table are compared between the two databases.
+//
+// The source database should not be modified while the copy is running.
+func CopyDatabase(ctx context.Context, src, dst *Database, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	log := zerolog.Ctx(ctx)
+	srcVersion, _, err := src.peekVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get source database version: %w", err)
+	}
+	dstVersion, _, err := dst.peekVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get target database version: %w", err)
+	}
+	if srcVersion != dstVersion || srcVersion == 0 {
+		return fmt.Errorf("%w (source is v%d, target is v%d)", ErrCopyVersionMismatch, srcVersion, dstVersion)
+	}
+	srcSchema, err := src.DumpSchema(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get source database schema: %w", err)
+	}
+	dstSchema, err := dst.DumpSchema(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get target database schema: %w", err)
+	}
+	skip := slices.Concat(src.versionTrackingTables(srcSchema), dst.versionTrackingTables(dstSchema), opts.SkipTables)
+	tables, err := copyOrder(srcSchema, skip)
+	if err != nil {
+		return err
+	}
+	return dst.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, srcTable := range tables {
+			dstTable := dstSchema.Table(srcTable.Name)
+			if dstTable == nil {
+				return fmt.Errorf("%w: table %s doesn't exist in target database", ErrCopySchemaMismatch, srcTable.Name)
+			}
+			copied, err := copyTable(ctx, src, dst, srcTable, dstTable, opts)
+			if err != nil {
+				return fmt.Errorf("failed to copy table %s: %w", srcTable.Name, err)
+			}
+			log.Debug().Str("table", srcTable.Name).Int("rows", copied).Msg("Copied table")
+		}
+		if dst.Dialect == Postgres {
+			for _, table := range tables {
+				if err = fixPostgresSequences(ctx, dst, table); err != nil {
+					return fmt.Errorf("failed to update sequences of %s: %w", table.Name, err)
+				}
+			}
+		}
+		for _, table := range tables {
+			if err = verifyRowCount(ctx, src, dst, table.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copyOrder sorts the tables in the schema so that tables come after all tables they reference.
+func copyOrder(schema *Schema, skip []string) ([]*SchemaTable, error) {
+	remaining := make(map[string]*SchemaTable, len(schema.Tables))
+	for _, table := range schema.Tables {
+		if !slices.Contains(skip, table.Name) {
+			remaining[table.Name] = table
+		}
+	}
+	ordered := make([]*SchemaTable, 0, len(remaining))
+	for len(remaining) > 0 {
+		var ready []*SchemaTable
+		for _, table := range remaining {
+			if !slices.ContainsFunc(table.Constraints, func(c SchemaConstraint) bool {
+				// Self-references are fine as long as rows are inserted in the right order
+				return c.Type == SchemaConstraintForeignKey && c.RefTable != table.Name && remaining[c.RefTable] != nil
+			}) {
+				ready = append(ready, table)
+			}
+		}
+		if len(ready) == 0 {
+			names := make([]string, 0, len(remaining))
+			for name := range remaining {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("%w: %s", ErrForeignKeyCycle, strings.Join(names, ", "))
+		}
+		slices.SortFunc(ready, func(a, b *SchemaTable) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		for _, table := range ready {
+			delete(remaining, table.Name)
+		}
+		ordered = append(ordered, ready...)
+	}
+	return ordered, nil
+}
+
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return quoted
+}
+
+// convertCopyValue converts a value read from the source database into something
+// the target database accepts for a column of the given normalized type.
+func convertCopyValue(val any, dstType string) any {
+	switch typedVal := val.(type) {
+	case int64:
+		if dstType == "boolean" {
+			return typedVal != 0
+		}
+	case []byte:
+		if dstType == "text" || dstType == "json" {
+			return string(typedVal)
+		}
+	}
+	return val
+}
+
+func copyTable(ctx context.Context, src, dst *Database, srcTable, dstTable *SchemaTable, opts *CopyOptions) (int, error) {
+	columns := make([]string, len(srcTable.Columns))
+	columnTypes := make([]string, len(srcTable.Columns))
+	for i, col := range srcTable.Columns {
+		idx := slices.IndexFunc(dstTable.Columns, func(dstCol SchemaColumn) bool {
+			return dstCol.Name == col.Name
+		})
+		if idx == -1 {
+			return 0, fmt.Errorf("%w: column %s doesn't exist in target database", ErrCopySchemaMismatch, col.Name)
+		}
+		columns[i] = col.Name
+		columnTypes[i] = dstTable.Columns[idx].Type
+	}
+	quotedTable := quoteIdentifiers([]string{srcTable.Name})[0]
+	var existing int
+	if err := dst.QueryRow(ctx, "SELECT COUNT(*) FROM "+quotedTable).Scan(&existing); err != nil {
+		return 0, fmt.Errorf("failed to count rows in target table: %w", err)
+	} else if existing > 0 {
+		return 0, ErrCopyTargetNotEmpty
+	}
+	var total int
+	if err := src.QueryRow(ctx, "SELECT COUNT(*) FROM "+quotedTable).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count rows in source table: %w", err)
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCopyBatchSize
+	}
+	quotedColumns := strings.Join(quoteIdentifiers(columns), ", ")
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s", quotedColumns, quotedTable)
+	var pk []string
+	for _, constraint := range srcTable.Constraints {
+		if constraint.Type == SchemaConstraintPrimaryKey {
+			pk = constraint.Columns
+		}
+	}
+	if len(pk) > 0 {
+		selectQuery += " ORDER BY " + strings.Join(quoteIdentifiers(pk), ", ")
+	}
+	// MassInsertBuilder can't be used directly, as its generic parameters require the number of columns
+	// to be known at compile time, so this uses the same non-generic building blocks as the builder.
+	singlePlaceholders := make([]string, len(columns))
+	for i := range singlePlaceholders {
+		singlePlaceholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	placeholderTemplate := "(" + strings.TrimSuffix(strings.Repeat("$%d, ", len(columns)), ", ") + ")"
+	insertTemplate := parseMassInsertQuery(
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quotedTable, quotedColumns, strings.Join(singlePlaceholders, ", ")),
+		placeholderTemplate, 0, len(columns),
+	)
+	batchSize = min(batchSize, massInsertChunkSize(dst.Dialect, 0, len(columns)))
+
+	rows, err := src.Query(ctx, selectQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query source table: %w", err)
+	}
+	defer rows.Close()
+	copied := 0
+	batch := make([][]any, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		query, params := buildMassInsert(insertTemplate, placeholderTemplate, nil, len(columns), len(batch), func(i int, into []any) {
+			copy(into, batch[i])
+		})
+		if _, err := dst.Exec(ctx, query, params...); err != nil {
+			return fmt.Errorf("failed to insert rows: %w", err)
+		}
+		copied += len(batch)
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(srcTable.Name, copied, total)
+		}
+		return nil
+	}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		scanTargets := make([]any, len(columns))
+		for i := range values {
+			scanTargets[i] = &values[i]
+		}
+		if err = rows.Scan(scanTargets...); err != nil {
+			return copied, fmt.Errorf("failed to scan row: %w", err)
+		}
+		for i, val := range values {
+			values[i] = convertCopyValue(val, columnTypes[i])
+		}
+		batch = append(batch, values)
+		if len(batch) >= batchSize {
+			if err = flush(); err != nil {
+				return copied, err
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return copied, fmt.Errorf("failed to read source table: %w", err)
+	}
+	return copied, flush()
+}
+
+func fixPostgresSequences(ctx context.Context, db *Database, table *SchemaTable) error {
+	quotedTable := quoteIdentifiers([]string{table.Name})[0]
+	for _, col := range table.Columns {
+		if col.Type != "integer" {
+			continue
+		}
+		var sequence *string
+		err := db.QueryRow(ctx, "SELECT pg_get_serial_sequence($1, $2)", quotedTable, col.Name).Scan(&sequence)
+		if err != nil {
+			return fmt.Errorf("failed to get sequence of column %s: %w", col.Name, err)
+		} else if sequence == nil {
+			continue
+		}
+		quotedColumn := quoteIdentifiers([]string{col.Name})[0]
+		_, err = db.Exec(ctx, fmt.Sprintf(
+			"SELECT setval($1, COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)", quotedColumn, quotedTable,
+		), *sequence)
+		if err != nil {
+			return fmt.Errorf("failed to update sequence %s: %w", *sequence, err)
+		}
+	}
+	return nil
+}
+
+func verifyRowCount(ctx context.Context, src, dst *Database, table string) error {
+	quotedTable := quoteIdentifiers([]string{table})[0]
+	var srcCount, dstCount int
+	if err := src.QueryRow(ctx, "SELECT COUNT(*) FROM "+quotedTable).Scan(&srcCount); err != nil {
+		return fmt.Errorf("failed to count rows in source table %s: %w", table, err)
+	} else if err = dst.QueryRow(ctx, "SELECT COUNT(*) FROM "+quotedTable).Scan(&dstCount); err != nil {
+		return fmt.Errorf("failed to count rows in target table %s: %w", table, err)
+	} else if srcCount != dstCount {
+		return fmt.Errorf("%w in %s: source has %d rows, target has %d", ErrCopyRowCountMismatch, table, srcCount, dstCount)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var copyTestUpgrades = fstest.MapFS{
+	"01-init.sql": {Data: []byte(`-- v1: Initial revision
+CREATE TABLE room (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE message (
+	id      INTEGER PRIMARY KEY,
+	room_id INTEGER NOT NULL REFERENCES room(id),
+	reply   INTEGER REFERENCES message(id),
+	body    TEXT NOT NULL,
+	edited  BOOLEAN NOT NULL DEFAULT false
+);
+`)},
+}
+
+func newCopyTestDB(t *testing.T) *Database {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.Owner = "test"
+	db.UpgradeTable = BuildUpgradeTable().WithFS(copyTestUpgrades).Finish()
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	require.NoError(t, db.Upgrade(context.Background()))
+	return db
+}
+
+func TestCopyDatabase(t *testing.T) {
+	ctx := context.Background()
+	src := newCopyTestDB(t)
+	dst := newCopyTestDB(t)
+	_, err := src.Exec(ctx, `
+		INSERT INTO room (id, name) VALUES (1, 'foo'), (2, 'bar');
+		INSERT INTO message (id, room_id, reply, body, edited) VALUES
+			(1, 1, NULL, 'hello', false),
+			(2, 1, 1, 'hi', true),
+			(3, 2, NULL, 'meow', false),
+			(4, 1, 2, 'hmm', false),
+			(5, 2, 3, 'meow?', true);
+	`)
+	require.NoError(t, err)
+	// Version tables of child databases are already filled in the target and must be skipped
+	for _, db := range []*Database{src, dst} {
+		child := db.Child("child_version", BuildUpgradeTable().WithRaw(0, 1, 0, "Init", TxnModeOn, func(ctx context.Context, db *Database) error {
+			return nil
+		}).Finish(), nil)
+		require.NoError(t, child.Upgrade(ctx))
+	}
+
+	var progress []int
+	err = CopyDatabase(ctx, src, dst, &CopyOptions{
+		BatchSize: 2,
+		Progress: func(table string, copied, total int) {
+			if table == "message" {
+				assert.Equal(t, 5, total)
+				progress = append(progress, copied)
+			}
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 5}, progress)
+	var body string
+	var edited bool
+	require.NoError(t, dst.QueryRow(ctx, "SELECT body, edited FROM message WHERE reply=3").Scan(&body, &edited))
+	assert.Equal(t, "meow?", body)
+	assert.True(t, edited)
+
+	err = CopyDatabase(ctx, src, dst, nil)
+	assert.ErrorIs(t, err, ErrCopyTargetNotEmpty)
+}
+
+func expectPostgresTableDump(mock sqlmock.Sqlmock, table string, columns [][]driver.Value, constraints [][]driver.Value) {
+	columnRows := sqlmock.NewRows([]string{"column_name", "data_type", "not_null"})
+	for _, col := range columns {
+		columnRows.AddRow(col...)
+	}
+	mock.ExpectQuery(listColumnsPostgres).WithArgs(table).WillReturnRows(columnRows)
+	constraintRows := sqlmock.NewRows([]string{"contype", "columns", "ref_table", "ref_columns", "on_update", "on_delete"})
+	for _, constraint := range constraints {
+		constraintRows.AddRow(constraint...)
+	}
+	mock.ExpectQuery(listConstraintsPostgres).WithArgs(table).WillReturnRows(constraintRows)
+	mock.ExpectQuery(listIndexesPostgres).WithArgs(table).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "unique", "columns"}))
+}
+
+func TestCopyDatabase_PostgresTarget(t *testing.T) {
+	ctx := context.Background()
+	src := newCopyTestDB(t)
+	_, err := src.Exec(ctx, `
+		INSERT INTO room (id, name) VALUES (1, 'foo');
+		INSERT INTO message (id, room_id, reply, body, edited) VALUES (1, 1, NULL, 'hello', false), (2, 1, 1, 'hi', true);
+	`)
+	require.NoError(t, err)
+
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	dst := &Database{
+		RawDB:        conn,
+		Log:          NoopLogger,
+		VersionTable: "version",
+		Dialect:      Postgres,
+		txnCtxKey:    contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+	}
+	dst.LoggingDB.UnderlyingExecable = conn
+	dst.LoggingDB.db = dst
+
+	mock.ExpectQuery(tableExistsPostgres).WithArgs("version").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	expectVersionCheck(Postgres, mock, 1, 1)
+	mock.ExpectQuery(listTablesPostgres).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("version").AddRow("room").AddRow("message"))
+	expectPostgresTableDump(mock, "message", [][]driver.Value{
+		{"id", "bigint", true}, {"room_id", "bigint", true}, {"reply", "bigint", false},
+		{"body", "text", true}, {"edited", "boolean", true},
+	}, [][]driver.Value{
+		{"p", "id", "", "", "a", "a"},
+		{"f", "room_id", "room", "id", "a", "a"},
+		{"f", "reply", "message", "id", "a", "a"},
+	})
+	expectPostgresTableDump(mock, "room", [][]driver.Value{
+		{"id", "bigint", true}, {"name", "text", true},
+	}, [][]driver.Value{{"p", "id", "", "", "a", "a"}})
+	expectPostgresTableDump(mock, "version", [][]driver.Value{
+		{"version", "integer", false}, {"compat", "integer", false},
+	}, nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COUNT(*) FROM "room"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`INSERT INTO "room" ("id", "name") VALUES ($1, $2)`).
+		WithArgs(int64(1), "foo").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT(*) FROM "message"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`INSERT INTO "message" ("id", "room_id", "reply", "body", "edited") VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10)`).
+		WithArgs(int64(1), int64(1), nil, "hello", false, int64(2), int64(1), int64(1), "hi", true).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT pg_get_serial_sequence($1, $2)").WithArgs(`"room"`, "id").
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow("public.room_id_seq"))
+	mock.ExpectExec(`SELECT setval($1, COALESCE((SELECT MAX("id") FROM "room"), 0) + 1, false)`).
+		WithArgs("public.room_id_seq").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, col := range []string{"id", "room_id", "reply"} {
+		mock.ExpectQuery("SELECT pg_get_serial_sequence($1, $2)").WithArgs(`"message"`, col).
+			WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(nil))
+	}
+	mock.ExpectQuery(`SELECT COUNT(*) FROM "room"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COUNT(*) FROM "message"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectCommit()
+
+	require.NoError(t, CopyDatabase(ctx, src, dst, nil))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCopyDatabase_VersionMismatch(t *testing.T) {
+	ctx := context.Background()
+	src := newCopyTestDB(t)
+	dst, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	err = CopyDatabase(ctx, src, dst, nil)
+	assert.ErrorIs(t, err, ErrCopyVersionMismatch)
+}
+
+func TestCopyOrder(t *testing.T) {
+	schema := &Schema{Tables: []*SchemaTable{
+		{Name: "a", Constraints: []SchemaConstraint{{Type: SchemaConstraintForeignKey, RefTable: "c"}}},
+		{Name: "b"},
+		{Name: "c", Constraints: []SchemaConstraint{{Type: SchemaConstraintForeignKey, RefTable: "b"}}},
+		{Name: "version"},
+	}}
+	ordered, err := copyOrder(schema, []string{"version"})
+	require.NoError(t, err)
+	names := make([]string, len(ordered))
+	for i, table := range ordered {
+		names[i] = table.Name
+	}
+	assert.Equal(t, []string{"b", "c", "a"}, names)
+
+	schema.Tables[1].Constraints = []SchemaConstraint{{Type: SchemaConstraintForeignKey, RefTable: "a"}}
+	_, err = copyOrder(schema, nil)
+	assert.ErrorIs(t, err, ErrForeignKeyCycle)
+}
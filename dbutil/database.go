@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.mau.fi/util/exsync"
@@ -115,12 +116,36 @@ type Database struct {
 	Dialect      Dialect
 	UpgradeTable UpgradeTable
 
+	// UpgradeHistoryTable is the name of an optional table where every applied upgrade is recorded.
+	// If set, checksums of previously applied SQL upgrades are also verified when upgrading.
+	UpgradeHistoryTable string
+	// AppVersion is the application version to store in the upgrade history table.
+	AppVersion string
+
+	// LockUpgrades makes Upgrade and Downgrade take a lock to prevent multiple instances from
+	// upgrading the same database concurrently. On Postgres, the lock is a session-level advisory
+	// lock, which means the lock holds one connection from the pool while upgrading.
+	LockUpgrades bool
+	// UpgradeLockTimeout is the maximum time to wait for the upgrade lock. Zero means no timeout.
+	UpgradeLockTimeout time.Duration
+
 	txnCtxKey      contextKey
 	txnDeadlockMap *exsync.Set[int64]
+	stmtCache      *stmtCache
+	// uri and driverName are the parameters the database was opened with, used by ScopedChild.
+	uri        string
+	driverName string
+
+	notificationTableCreated atomic.Bool
 
 	IgnoreForeignTables       bool
 	IgnoreUnsupportedDatabase bool
 	DeadlockDetection         bool
+	// AutoReadOnly makes Query and QueryRow calls outside transactions use ReadOnlyDB (if it's set).
+	// The behavior can be overridden for individual contexts with [ForcePrimaryDB] and [PreferReadOnlyDB].
+	AutoReadOnly bool
+	// Metrics is an optional collector that receives metrics about all queries and transactions.
+	Metrics MetricsCollector
 }
 
 var ForceDeadlockDetection bool
@@ -165,6 +190,7 @@ func (db *Database) Child(versionTable string, upgradeTable UpgradeTable, log Da
 	}
 	return &Database{
 		RawDB:        db.RawDB,
+		ReadOnlyDB:   db.ReadOnlyDB,
 		LoggingDB:    db.LoggingDB,
 		Owner:        "",
 		VersionTable: versionTable,
@@ -172,12 +198,20 @@ func (db *Database) Child(versionTable string, upgradeTable UpgradeTable, log Da
 		Log:          log,
 		Dialect:      db.Dialect,
 
+		LockUpgrades:       db.LockUpgrades,
+		UpgradeLockTimeout: db.UpgradeLockTimeout,
+
 		txnCtxKey:      db.txnCtxKey,
 		txnDeadlockMap: db.txnDeadlockMap,
+		stmtCache:      db.stmtCache,
+		uri:            db.uri,
+		driverName:     db.driverName,
 
 		IgnoreForeignTables:       true,
 		IgnoreUnsupportedDatabase: db.IgnoreUnsupportedDatabase,
 		DeadlockDetection:         db.DeadlockDetection,
+		AutoReadOnly:              db.AutoReadOnly,
+		Metrics:                   db.Metrics,
 	}
 }
 
@@ -210,7 +244,13 @@ func NewWithDialect(uri, rawDialect string) (*Database, error) {
 		return nil, err
 	}
 
-	return NewWithDB(db, rawDialect)
+	wrappedDB, err := NewWithDB(db, rawDialect)
+	if err != nil {
+		return nil, err
+	}
+	wrappedDB.uri = uri
+	wrappedDB.driverName = rawDialect
+	return wrappedDB, nil
 }
 
 type PoolConfig struct {
@@ -229,9 +269,15 @@ type Config struct {
 	ReadOnlyPool PoolConfig `yaml:"ro_pool"`
 
 	DeadlockDetection bool `yaml:"deadlock_detection"`
+	AutoReadOnly      bool `yaml:"auto_read_only"`
+	// The size of the prepared statement cache. Zero leaves the current cache as-is and a negative value disables it.
+	StatementCacheSize int `yaml:"statement_cache_size"`
 }
 
 func (db *Database) Close() error {
+	if db.stmtCache != nil {
+		db.stmtCache.close()
+	}
 	err := db.RawDB.Close()
 	if db.ReadOnlyDB != nil {
 		if err2 := db.ReadOnlyDB.Close(); err2 != nil {
@@ -247,6 +293,11 @@ func (db *Database) Close() error {
 
 func (db *Database) Configure(cfg Config) error {
 	db.DeadlockDetection = cfg.DeadlockDetection || ForceDeadlockDetection
+	db.AutoReadOnly = cfg.AutoReadOnly
+	// Only touch the cache if the config specifies a size, so that a cache enabled in code isn't disabled
+	if cfg.StatementCacheSize != 0 {
+		db.EnableStatementCache(cfg.StatementCacheSize)
+	}
 
 	if err := db.configure(db.ReadOnlyDB, cfg.ReadOnlyPool); err != nil {
 		return err
@@ -1,8 +1,15 @@
 package dbutil
 
 import (
+	"context"
+	"path/filepath"
 	"regexp"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "go.mau.fi/util/dbutil/litestream"
 )
 
 var positionalParamRegex = regexp.MustCompile(`\$(\d+)`)
@@ -88,3 +95,35 @@ func BenchmarkReplacePositionalParams(b *testing.B) {
 		}
 	})
 }
+
+func TestDatabase_AutoReadOnly(t *testing.T) {
+	db, err := NewFromConfig("", Config{
+		PoolConfig: PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          "file:" + filepath.Join(t.TempDir(), "test.db") + "?_txlock=immediate",
+			MaxOpenConns: 1,
+			MaxIdleConns: 1,
+		},
+		ReadOnlyPool: PoolConfig{
+			MaxOpenConns: 1,
+			MaxIdleConns: 1,
+		},
+		AutoReadOnly: true,
+	}, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	isQueryOnly := func(ctx context.Context) (queryOnly bool) {
+		require.NoError(t, db.QueryRow(ctx, "PRAGMA query_only").Scan(&queryOnly))
+		return
+	}
+	ctx := context.Background()
+	assert.True(t, isQueryOnly(ctx))
+	assert.False(t, isQueryOnly(ForcePrimaryDB(ctx)))
+	require.NoError(t, db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		assert.False(t, isQueryOnly(ctx))
+		return nil
+	}))
+	db.AutoReadOnly = false
+	assert.False(t, isQueryOnly(ctx))
+	assert.True(t, isQueryOnly(PreferReadOnlyDB(ctx)))
+}
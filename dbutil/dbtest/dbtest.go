@@ -0,0 +1,153 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package dbtest contains helpers for testing code that uses [dbutil.Database].
+//
+// All tests run on a fresh in-memory SQLite database. If the environment variable named by
+// [PostgresURIEnv] is set, tests using [ForEachDialect] also run on Postgres, with every test
+// isolated in its own temporary schema.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+	"go.mau.fi/util/random"
+)
+
+const (
+	// PostgresURIEnv is the environment variable containing the URI of the Postgres database to run tests on.
+	PostgresURIEnv = "DBUTIL_TEST_POSTGRES_URI"
+	// PostgresDriverEnv is the environment variable that can override the database/sql driver used for Postgres.
+	// The default is "postgres" (lib/pq). Other drivers must be imported by the test.
+	PostgresDriverEnv = "DBUTIL_TEST_POSTGRES_DRIVER"
+)
+
+// NewTestDatabase creates a fresh in-memory SQLite database and runs the upgrades in the given table.
+// The database is closed automatically when the test finishes.
+func NewTestDatabase(t testing.TB, upgradeTable dbutil.UpgradeTable) *dbutil.Database {
+	t.Helper()
+	db := NewEmptySQLite(t)
+	db.UpgradeTable = upgradeTable
+	require.NoError(t, db.Upgrade(context.Background()), "failed to upgrade test database")
+	return db
+}
+
+// NewTestPostgres creates a database in a new temporary schema on the Postgres server specified
+// by [PostgresURIEnv] and runs the upgrades in the given table. If the environment variable is not set,
+// the test is skipped. The schema is dropped automatically when the test finishes.
+func NewTestPostgres(t testing.TB, upgradeTable dbutil.UpgradeTable) *dbutil.Database {
+	t.Helper()
+	db := NewEmptyPostgres(t)
+	db.UpgradeTable = upgradeTable
+	require.NoError(t, db.Upgrade(context.Background()), "failed to upgrade test database")
+	return db
+}
+
+// NewEmptySQLite creates a fresh in-memory SQLite database without running any upgrades.
+func NewEmptySQLite(t testing.TB) *dbutil.Database {
+	t.Helper()
+	db, err := dbutil.NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	// Every connection to :memory: is a separate database, so only allow one connection.
+	db.RawDB.SetMaxOpenConns(1)
+	db.DeadlockDetection = true
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+// NewEmptyPostgres creates a database in a new temporary Postgres schema without running any upgrades.
+// If [PostgresURIEnv] is not set, the test is skipped.
+func NewEmptyPostgres(t testing.TB) *dbutil.Database {
+	t.Helper()
+	uri := os.Getenv(PostgresURIEnv)
+	if uri == "" {
+		t.Skipf("%s not set, skipping Postgres test", PostgresURIEnv)
+	}
+	driverName := os.Getenv(PostgresDriverEnv)
+	if driverName == "" {
+		driverName = "postgres"
+	}
+	parent, err := dbutil.NewWithDialect(uri, driverName)
+	require.NoError(t, err)
+	ctx := context.Background()
+	schema := "dbtest_" + strings.ToLower(random.String(12))
+	db, err := parent.ScopedChild(ctx, schema, "version", nil, nil)
+	if err != nil {
+		_ = parent.Close()
+		require.NoError(t, err, "failed to create test schema")
+	}
+	db.DeadlockDetection = true
+	t.Cleanup(func() {
+		_ = db.Close()
+		_, err := parent.Exec(ctx, fmt.Sprintf(`DROP SCHEMA "%s" CASCADE`, schema))
+		if err != nil {
+			t.Errorf("Failed to drop test schema %s: %v", schema, err)
+		}
+		_ = parent.Close()
+	})
+	return db
+}
+
+// ForEachDialect runs the given function as a subtest for each available database dialect.
+// The database passed to the function has been upgraded with the given upgrade table.
+func ForEachDialect(t *testing.T, upgradeTable dbutil.UpgradeTable, fn func(t *testing.T, db *dbutil.Database)) {
+	t.Helper()
+	t.Run("sqlite", func(t *testing.T) {
+		fn(t, NewTestDatabase(t, upgradeTable))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		fn(t, NewTestPostgres(t, upgradeTable))
+	})
+}
+
+// AssertUpgrades validates the upgrade table with [dbutil.UpgradeTable.Validate], checks that the latest
+// version is reachable from v0 without gaps, and that running the upgrades works on each available
+// database dialect.
+func AssertUpgrades(t *testing.T, upgradeTable dbutil.UpgradeTable) {
+	t.Helper()
+	require.NoError(t, upgradeTable.Validate(), "upgrade table is invalid")
+	check := func(t *testing.T, db *dbutil.Database) {
+		ctx := context.Background()
+		db.UpgradeTable = upgradeTable
+		plan, err := db.PlanUpgrade(ctx)
+		require.NoError(t, err)
+		version := 0
+		for _, step := range plan {
+			require.Equal(t, version, step.From, "upgrade path from v0 skips v%d", version)
+			version = step.To
+		}
+		require.Equal(t, len(upgradeTable), version, "upgrade path from v0 doesn't reach the latest version")
+		require.NoError(t, db.Upgrade(ctx))
+		plan, err = db.PlanUpgrade(ctx)
+		require.NoError(t, err)
+		require.Empty(t, plan, "database is not up to date after upgrading")
+	}
+	t.Run("sqlite", func(t *testing.T) {
+		check(t, NewEmptySQLite(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		check(t, NewEmptyPostgres(t))
+	})
+}
+
+// AssertSchemaSnapshot checks the schema of the database against the given snapshot file
+// using [dbutil.Database.CheckSchemaSnapshot], printing the differences if it doesn't match.
+func AssertSchemaSnapshot(t testing.TB, db *dbutil.Database, path string) {
+	t.Helper()
+	err := db.CheckSchemaSnapshot(context.Background(), path)
+	require.NoError(t, err, "schema doesn't match snapshot (run tests with %s=1 to update it)", dbutil.UpdateSchemaSnapshotsEnv)
+}
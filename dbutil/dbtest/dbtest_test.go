@@ -0,0 +1,75 @@
+package dbtest_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/dbutil/dbtest"
+)
+
+var testUpgrades = fstest.MapFS{
+	"01-initial.sql": {Data: []byte(`-- v0 -> v1: Initial schema
+CREATE TABLE users (
+	id   INTEGER PRIMARY KEY,
+	name TEXT    NOT NULL
+);
+CREATE TABLE messages (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	content jsonb   NOT NULL
+);
+`)},
+	"02-flags.sql": {Data: []byte(`-- v2: Add flags
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT false;
+`)},
+}
+
+var testUpgradeTable = dbutil.BuildUpgradeTable().WithFS(testUpgrades).Finish()
+
+var testFixtures = fstest.MapFS{
+	"fixtures.yaml": {Data: []byte(`
+users:
+  - id: 1
+    name: meow
+    admin: true
+  - id: 2
+    name: hmm
+messages:
+  - id: 1
+    user_id: 2
+    content: {"body": "hello"}
+`)},
+	"fixtures.json": {Data: []byte(`{"messages": [{"id": 2, "user_id": 1, "content": ["a", "b"]}]}`)},
+}
+
+func TestAssertUpgrades(t *testing.T) {
+	dbtest.AssertUpgrades(t, testUpgradeTable)
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dbtest.ForEachDialect(t, testUpgradeTable, func(t *testing.T, db *dbutil.Database) {
+		dbtest.LoadFixtures(t, db, testFixtures, "fixtures.yaml", "fixtures.json")
+		ctx := context.Background()
+		var name string
+		var admin bool
+		require.NoError(t, db.QueryRow(ctx, "SELECT name, admin FROM users WHERE id=1").Scan(&name, &admin))
+		assert.Equal(t, "meow", name)
+		assert.True(t, admin)
+		var content string
+		require.NoError(t, db.QueryRow(ctx, "SELECT content FROM messages WHERE id=1").Scan(&content))
+		assert.JSONEq(t, `{"body": "hello"}`, content)
+		require.NoError(t, db.QueryRow(ctx, "SELECT content FROM messages WHERE id=2").Scan(&content))
+		assert.JSONEq(t, `["a", "b"]`, content)
+	})
+}
+
+func TestLoadFixtureData_ForeignKeyOrder(t *testing.T) {
+	db := dbtest.NewTestDatabase(t, testUpgradeTable)
+	err := dbtest.LoadFixtureData(context.Background(), db, []byte(`{"messages": [{"id": 1, "user_id": 1, "content": "{}"}]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/util/dbutil"
+)
+
+// LoadFixtures inserts the rows in the given fixture files into the database.
+//
+// Fixture files are YAML or JSON objects where keys are table names and values are lists of rows.
+// Each row is an object from column names to values. Tables are inserted in the order they appear in
+// the file, so tables referenced by foreign keys should come first. Nested objects and lists are
+// inserted as JSON.
+//
+//	users:
+//	  - id: 1
+//	    name: meow
+//	messages:
+//	  - user_id: 1
+//	    content: {"body": "hello"}
+func LoadFixtures(t testing.TB, db *dbutil.Database, fsys fs.FS, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		require.NoError(t, err)
+		require.NoError(t, LoadFixtureData(context.Background(), db, data), "failed to load fixtures from %s", path)
+	}
+}
+
+// LoadFixtureData inserts the rows in the given fixture data into the database.
+// See [LoadFixtures] for the format.
+func LoadFixtureData(ctx context.Context, db *dbutil.Database, data []byte) error {
+	// YAML is a superset of JSON, so the YAML parser handles both formats.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse fixtures: %w", err)
+	} else if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("fixture root must be an object of tables")
+	}
+	return db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			table := root.Content[i].Value
+			var rows []map[string]any
+			if err := root.Content[i+1].Decode(&rows); err != nil {
+				return fmt.Errorf("failed to parse rows of %s: %w", table, err)
+			}
+			for j, row := range rows {
+				if err := insertFixtureRow(ctx, db, table, row); err != nil {
+					return fmt.Errorf("failed to insert row #%d into %s: %w", j+1, table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func insertFixtureRow(ctx context.Context, db *dbutil.Database, table string, row map[string]any) error {
+	columns := slices.Sorted(maps.Keys(row))
+	placeholders := make([]string, len(columns))
+	values := make([]any, len(columns))
+	for i, col := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		switch val := row[col].(type) {
+		case map[string]any, []any:
+			encoded, err := json.Marshal(val)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", col, err)
+			}
+			values[i] = string(encoded)
+		default:
+			values[i] = val
+		}
+	}
+	_, err := db.Exec(
+		ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", ")),
+		values...,
+	)
+	return err
+}
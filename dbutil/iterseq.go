@@ -0,0 +1,122 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"iter"
+)
+
+// RowIterSeq returns an iterator over the rows of the given RowIter that can be used with range loops.
+//
+// If an error occurs, it's yielded with a zero value as the last item.
+// Breaking out of the loop early stops the iteration and closes the rows.
+func RowIterSeq[T any](ri RowIter[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		stopped := false
+		err := ri.Iter(func(item T) (bool, error) {
+			stopped = !yield(item, nil)
+			return !stopped, nil
+		})
+		if err != nil && !stopped {
+			yield(*new(T), err)
+		}
+	}
+}
+
+type funcRowIter[T any] struct {
+	iter func(fn func(T) (bool, error)) error
+	err  error
+}
+
+func (i *funcRowIter[T]) Iter(fn func(T) (bool, error)) error {
+	if i.err != nil {
+		return i.err
+	}
+	i.err = ErrAlreadyIterated
+	return i.iter(fn)
+}
+
+func (i *funcRowIter[T]) AsList() (list []T, err error) {
+	err = i.Iter(func(item T) (bool, error) {
+		list = append(list, item)
+		return true, nil
+	})
+	return
+}
+
+// RowIterMap returns a RowIter that converts each item of the given RowIter using the given function.
+// If the function returns an error, the iteration is stopped and the error is returned.
+func RowIterMap[T, U any](ri RowIter[T], fn func(T) (U, error)) RowIter[U] {
+	return &funcRowIter[U]{iter: func(yield func(U) (bool, error)) error {
+		return ri.Iter(func(item T) (bool, error) {
+			converted, err := fn(item)
+			if err != nil {
+				return false, err
+			}
+			return yield(converted)
+		})
+	}}
+}
+
+// RowIterFilter returns a RowIter that only includes items of the given RowIter for which the function returns true.
+func RowIterFilter[T any](ri RowIter[T], fn func(T) bool) RowIter[T] {
+	return &funcRowIter[T]{iter: func(yield func(T) (bool, error)) error {
+		return ri.Iter(func(item T) (bool, error) {
+			if !fn(item) {
+				return true, nil
+			}
+			return yield(item)
+		})
+	}}
+}
+
+// RowIterFirst returns the first item of the given RowIter and stops the iteration.
+// If there are no items, the zero value is returned with no error.
+func RowIterFirst[T any](ri RowIter[T]) (first T, err error) {
+	err = ri.Iter(func(item T) (bool, error) {
+		first = item
+		return false, nil
+	})
+	return
+}
+
+// RowIterBatch returns an iterator that yields the items of the given RowIter in slices of up to n items.
+//
+// The same slice is not reused between batches, so it's safe to keep references to them.
+// If an error occurs, it's yielded with any remaining items as the last batch.
+func RowIterBatch[T any](ri RowIter[T], n int) iter.Seq2[[]T, error] {
+	if n <= 0 {
+		panic("RowIterBatch: batch size must be positive")
+	}
+	return func(yield func([]T, error) bool) {
+		batch := make([]T, 0, n)
+		stopped := false
+		err := ri.Iter(func(item T) (bool, error) {
+			batch = append(batch, item)
+			if len(batch) >= n {
+				stopped = !yield(batch, nil)
+				batch = make([]T, 0, n)
+			}
+			return !stopped, nil
+		})
+		if !stopped && (len(batch) > 0 || err != nil) {
+			yield(batch, err)
+		}
+	}
+}
+
+// CollectSeq collects all items from an iterator returned by [RowIterSeq] into a slice.
+// If the iterator yields an error, the items collected so far are returned along with the error.
+func CollectSeq[T any](seq iter.Seq2[T, error]) (list []T, err error) {
+	for item, err := range seq {
+		if err != nil {
+			return list, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
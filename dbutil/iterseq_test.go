@@ -0,0 +1,95 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newIterTestDB(t *testing.T) *Database {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	_, err = db.Exec(context.Background(), `
+		CREATE TABLE foo (id INTEGER PRIMARY KEY);
+		INSERT INTO foo (id) VALUES (1), (2), (3), (4), (5);
+	`)
+	require.NoError(t, err)
+	return db
+}
+
+var scanInt = ConvertRowFn[int](ScanSingleColumn[int])
+
+func TestRowIter_Seq(t *testing.T) {
+	db := newIterTestDB(t)
+	ctx := context.Background()
+	var ids []int
+	for id, err := range RowIterSeq(scanInt.NewRowIter(db.Query(ctx, "SELECT id FROM foo ORDER BY id"))) {
+		require.NoError(t, err)
+		ids = append(ids, id)
+		if id == 3 {
+			break
+		}
+	}
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	// The rows must be closed after breaking, otherwise this would block with a single connection
+	ids, err := CollectSeq(RowIterSeq(scanInt.NewRowIter(db.Query(ctx, "SELECT id FROM foo ORDER BY id"))))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+
+	ids, err = CollectSeq(RowIterSeq(NewSliceIterWithError[int](nil, errors.New("meow"))))
+	assert.Error(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestRowIterHelpers(t *testing.T) {
+	db := newIterTestDB(t)
+	ctx := context.Background()
+	query := func() RowIter[int] {
+		return scanInt.NewRowIter(db.Query(ctx, "SELECT id FROM foo ORDER BY id"))
+	}
+
+	doubled, err := RowIterMap(query(), func(id int) (int, error) {
+		return id * 2, nil
+	}).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6, 8, 10}, doubled)
+
+	odd, err := RowIterFilter(query(), func(id int) bool {
+		return id%2 == 1
+	}).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5}, odd)
+
+	errMap := errors.New("map failed")
+	_, err = RowIterMap(query(), func(id int) (int, error) {
+		return 0, errMap
+	}).AsList()
+	assert.ErrorIs(t, err, errMap)
+
+	first, err := RowIterFirst(query())
+	require.NoError(t, err)
+	assert.Equal(t, 1, first)
+	first, err = RowIterFirst(scanInt.NewRowIter(db.Query(ctx, "SELECT id FROM foo WHERE id > 10")))
+	require.NoError(t, err)
+	assert.Equal(t, 0, first)
+
+	var batches [][]int
+	for batch, err := range RowIterBatch(query(), 2) {
+		require.NoError(t, err)
+		batches = append(batches, batch)
+	}
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, batches)
+	batches = nil
+	for batch, err := range RowIterBatch(query(), 2) {
+		require.NoError(t, err)
+		batches = append(batches, batch)
+		break
+	}
+	assert.Equal(t, [][]int{{1, 2}}, batches)
+	_, err = RowIterFirst(query())
+	require.NoError(t, err)
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package jobqueue implements a durable background job queue on top of [dbutil.Database].
+//
+// Jobs are stored in the dbutil_jobs table, which has its own version table (dbutil_jobs_version),
+// so the queue can be added to any existing database with [Queue.Upgrade]. Multiple named queues
+// can share the same table.
+package jobqueue
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/util/dbutil"
+)
+
+//go:embed upgrades/*.sql
+var upgrades embed.FS
+
+// UpgradeTable contains the schema of the job table.
+var UpgradeTable = dbutil.BuildUpgradeTable().WithFSPath(upgrades, "upgrades").Finish()
+
+// VersionTable is the name of the version table used for [UpgradeTable].
+const VersionTable = "dbutil_jobs_version"
+
+// JobState is the state of a job in the database.
+type JobState string
+
+const (
+	JobStatePending JobState = "pending"
+	JobStateRunning JobState = "running"
+	JobStateFailed  JobState = "failed"
+)
+
+// Job is a single job in the queue.
+type Job struct {
+	ID          int64
+	Queue       string
+	Payload     []byte
+	Priority    int
+	State       JobState
+	RunAt       time.Time
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	CreatedAt   time.Time
+}
+
+func (job *Job) Scan(row dbutil.Scannable) (*Job, error) {
+	var runAt, createdAt int64
+	var lastError sql.NullString
+	err := row.Scan(
+		&job.ID, &job.Queue, &job.Payload, &job.Priority, &job.State, &runAt,
+		&job.Attempts, &job.MaxAttempts, &lastError, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	job.RunAt = time.UnixMilli(runAt)
+	job.CreatedAt = time.UnixMilli(createdAt)
+	job.LastError = lastError.String
+	return job, nil
+}
+
+const (
+	jobColumns = "id, queue, payload, priority, state, run_at, attempts, max_attempts, last_error, created_at"
+
+	enqueueQuery = `
+		INSERT INTO dbutil_jobs (queue, payload, priority, run_at, max_attempts, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	// The inner SELECT only uses FOR UPDATE SKIP LOCKED on Postgres. On SQLite, the whole update
+	// is atomic anyway as there's only one writer at a time.
+	claimQueryTemplate = `
+		UPDATE dbutil_jobs
+		SET state='running', locked_until=$3, attempts=attempts+1
+		WHERE id=(
+			SELECT id FROM dbutil_jobs
+			WHERE queue=$1 AND run_at<=$2 AND (
+				state='pending' OR (state='running' AND locked_until<=$2 AND attempts<max_attempts)
+			)
+			ORDER BY priority DESC, run_at, id
+			LIMIT 1
+			%s
+		)
+		RETURNING ` + jobColumns
+	// Jobs whose visibility timeout expired on the last attempt (e.g. because the job crashed the process)
+	// can't be retried, so they're moved to the failed state instead of being claimed again.
+	failExpiredQuery = `
+		UPDATE dbutil_jobs SET state='failed', locked_until=NULL, last_error=$3
+		WHERE queue=$1 AND state='running' AND locked_until<=$2 AND attempts>=max_attempts
+	`
+	completeQuery = `DELETE FROM dbutil_jobs WHERE id=$1 AND attempts=$2 AND state='running'`
+	retryQuery    = `
+		UPDATE dbutil_jobs SET state='pending', run_at=$3, locked_until=NULL, last_error=$4
+		WHERE id=$1 AND attempts=$2 AND state='running'
+	`
+	failQuery = `
+		UPDATE dbutil_jobs SET state='failed', locked_until=NULL, last_error=$3
+		WHERE id=$1 AND attempts=$2 AND state='running'
+	`
+	extendQuery    = `UPDATE dbutil_jobs SET locked_until=$3 WHERE id=$1 AND attempts=$2 AND state='running'`
+	getQuery       = `SELECT ` + jobColumns + ` FROM dbutil_jobs WHERE id=$1`
+	getFailedQuery = `SELECT ` + jobColumns + ` FROM dbutil_jobs WHERE queue=$1 AND state='failed' ORDER BY id`
+	requeueQuery   = `
+		UPDATE dbutil_jobs SET state='pending', run_at=$3, attempts=0, locked_until=NULL
+		WHERE queue=$1 AND id=$2 AND state='failed'
+	`
+)
+
+// ErrJobLost is returned when completing, failing or extending a job that was claimed by another worker
+// after its visibility timeout expired, or that was otherwise removed from the queue.
+var ErrJobLost = errors.New("job is no longer claimed by this worker")
+
+const errVisibilityTimeoutExpired = "visibility timeout expired on last attempt"
+
+// DefaultBackoff is the default retry backoff: 10 seconds after the first attempt,
+// doubling after every attempt up to one hour.
+func DefaultBackoff(attempts int) time.Duration {
+	backoff := 10 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	return min(backoff, time.Hour)
+}
+
+// Queue is a named job queue.
+type Queue struct {
+	db   *dbutil.Database
+	Name string
+
+	// VisibilityTimeout is how long a claimed job is hidden from other workers.
+	// If the job isn't completed or failed within the timeout (e.g. because the process crashed),
+	// it will be claimed again. Long-running handlers can call [Queue.Extend] to keep the job claimed.
+	VisibilityTimeout time.Duration
+	// MaxAttempts is the default maximum number of attempts for new jobs.
+	MaxAttempts int
+	// Backoff returns the delay before retrying a job that has failed the given number of times.
+	Backoff func(attempts int) time.Duration
+	// PollInterval is how often idle workers check for new jobs.
+	PollInterval time.Duration
+
+	wakeup chan struct{}
+}
+
+// New creates a new queue with the given name. The database is wrapped with [dbutil.Database.Child],
+// so the job table has its own version table, while transactions are still shared with the parent:
+// jobs enqueued inside a transaction are only visible to workers after the transaction is committed.
+func New(db *dbutil.Database, name string) *Queue {
+	return &Queue{
+		db:   db.Child(VersionTable, UpgradeTable, nil),
+		Name: name,
+
+		VisibilityTimeout: 5 * time.Minute,
+		MaxAttempts:       5,
+		Backoff:           DefaultBackoff,
+		PollInterval:      5 * time.Second,
+
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// Upgrade creates or upgrades the job table.
+func (q *Queue) Upgrade(ctx context.Context) error {
+	return q.db.Upgrade(ctx)
+}
+
+// EnqueueOptions contains optional parameters for [Queue.Enqueue].
+type EnqueueOptions struct {
+	// RunAt is the earliest time the job should be run. Defaults to now.
+	RunAt time.Time
+	// Priority of the job. Jobs with a higher priority are claimed first.
+	Priority int
+	// MaxAttempts overrides the queue's default maximum number of attempts.
+	MaxAttempts int
+}
+
+// Enqueue adds a new job to the queue and returns its ID.
+func (q *Queue) Enqueue(ctx context.Context, payload []byte, opts *EnqueueOptions) (int64, error) {
+	if opts == nil {
+		opts = &EnqueueOptions{}
+	}
+	now := time.Now()
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = now
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.MaxAttempts
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	var id int64
+	// INSERT ... RETURNING must go to the primary database even if AutoReadOnly is enabled
+	err := q.db.QueryRow(
+		dbutil.ForcePrimaryDB(ctx), enqueueQuery, q.Name, payload, opts.Priority, runAt.UnixMilli(), maxAttempts, now.UnixMilli(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert job: %w", err)
+	}
+	if !runAt.After(now) {
+		select {
+		case q.wakeup <- struct{}{}:
+		default:
+		}
+	}
+	return id, nil
+}
+
+// Claim claims the next available job. If there are no jobs available, this returns nil.
+//
+// The job must be passed to [Queue.Complete] or [Queue.Fail] after processing it.
+func (q *Queue) Claim(ctx context.Context) (*Job, error) {
+	var lockClause string
+	if q.db.Dialect == dbutil.Postgres {
+		lockClause = "FOR UPDATE SKIP LOCKED"
+	}
+	now := time.Now()
+	_, err := q.db.Exec(ctx, failExpiredQuery, q.Name, now.UnixMilli(), errVisibilityTimeoutExpired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fail expired jobs: %w", err)
+	}
+	job, err := (&Job{}).Scan(q.db.QueryRow(
+		dbutil.ForcePrimaryDB(ctx), fmt.Sprintf(claimQueryTemplate, lockClause),
+		q.Name, now.UnixMilli(), now.Add(q.VisibilityTimeout).UnixMilli(),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	return job, nil
+}
+
+func (q *Queue) execClaimed(ctx context.Context, query string, args ...any) error {
+	res, err := q.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	} else if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Complete removes a successfully processed job from the queue.
+func (q *Queue) Complete(ctx context.Context, job *Job) error {
+	return q.execClaimed(ctx, completeQuery, job.ID, job.Attempts)
+}
+
+// Fail marks a claimed job as failed. If the job has attempts left, it will be retried after the
+// backoff returned by [Queue.Backoff]. Otherwise, the job stays in the failed state until it's
+// requeued with [Queue.Requeue].
+func (q *Queue) Fail(ctx context.Context, job *Job, jobErr error) error {
+	errText := "unknown error"
+	if jobErr != nil {
+		errText = jobErr.Error()
+	}
+	job.LastError = errText
+	if job.Attempts >= job.MaxAttempts {
+		job.State = JobStateFailed
+		return q.execClaimed(ctx, failQuery, job.ID, job.Attempts, errText)
+	}
+	job.State = JobStatePending
+	job.RunAt = time.Now().Add(q.Backoff(job.Attempts))
+	return q.execClaimed(ctx, retryQuery, job.ID, job.Attempts, job.RunAt.UnixMilli(), errText)
+}
+
+// Extend pushes the visibility timeout of a claimed job forward, so it isn't claimed by another worker.
+func (q *Queue) Extend(ctx context.Context, job *Job) error {
+	return q.execClaimed(ctx, extendQuery, job.ID, job.Attempts, time.Now().Add(q.VisibilityTimeout).UnixMilli())
+}
+
+// Get returns the job with the given ID, or nil if it doesn't exist (e.g. because it was completed).
+func (q *Queue) Get(ctx context.Context, id int64) (*Job, error) {
+	job, err := (&Job{}).Scan(q.db.QueryRow(ctx, getQuery, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// GetFailed returns all jobs in the queue that have run out of attempts.
+func (q *Queue) GetFailed(ctx context.Context) ([]*Job, error) {
+	return dbutil.ConvertRowFn[*Job](func(row dbutil.Scannable) (*Job, error) {
+		return (&Job{}).Scan(row)
+	}).NewRowIter(q.db.Query(ctx, getFailedQuery, q.Name)).AsList()
+}
+
+// Requeue resets the attempt counter of a failed job and makes it available to run immediately.
+func (q *Queue) Requeue(ctx context.Context, id int64) error {
+	res, err := q.db.Exec(ctx, requeueQuery, q.Name, id, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	} else if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("job %d is not a failed job in queue %s", id, q.Name)
+	}
+	return nil
+}
+
+// Handler processes a single job. Returning an error (or panicking) fails the job,
+// which means it'll be retried later if it has attempts left.
+type Handler func(ctx context.Context, job *Job) error
+
+// Run starts the given number of workers that process jobs with the handler.
+// It blocks until the context is canceled and all workers have finished their current job.
+func (q *Queue) Run(ctx context.Context, workers int, handler Handler) {
+	if workers <= 0 {
+		workers = 1
+	}
+	log := zerolog.Ctx(ctx).With().Str("job_queue", q.Name).Logger()
+	ctx = log.WithContext(ctx)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			q.worker(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) worker(ctx context.Context, handler Handler) {
+	log := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		job, err := q.Claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("Failed to claim job")
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			case <-q.wakeup:
+			}
+			continue
+		}
+		q.process(ctx, job, handler)
+	}
+}
+
+func (q *Queue) process(ctx context.Context, job *Job, handler Handler) {
+	log := zerolog.Ctx(ctx).With().
+		Int64("job_id", job.ID).
+		Int("job_attempt", job.Attempts).
+		Logger()
+	ctx = log.WithContext(ctx)
+	err := q.callHandler(ctx, job, handler)
+	// Use a context that isn't canceled, so that the job state is stored even if the queue is stopping.
+	storeCtx := context.WithoutCancel(ctx)
+	if err == nil {
+		err = q.Complete(storeCtx, job)
+		if err != nil {
+			log.Err(err).Msg("Failed to mark job as completed")
+		} else {
+			log.Debug().Msg("Job completed")
+		}
+		return
+	}
+	logEvt := log.Warn()
+	if job.Attempts >= job.MaxAttempts {
+		logEvt = log.Error()
+	}
+	logEvt.Err(err).Int("max_attempts", job.MaxAttempts).Msg("Job failed")
+	if err = q.Fail(storeCtx, job, err); err != nil {
+		log.Err(err).Msg("Failed to mark job as failed")
+	}
+}
+
+func (q *Queue) callHandler(ctx context.Context, job *Job, handler Handler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			zerolog.Ctx(ctx).Error().
+				Bytes(zerolog.ErrorStackFieldName, debug.Stack()).
+				Any(zerolog.ErrorFieldName, p).
+				Msg("Panic in job handler")
+			err = fmt.Errorf("panic in job handler: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
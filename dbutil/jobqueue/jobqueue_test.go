@@ -0,0 +1,206 @@
+package jobqueue_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/dbutil/jobqueue"
+	_ "go.mau.fi/util/dbutil/litestream"
+)
+
+func initTestQueue(t *testing.T) (*dbutil.Database, *jobqueue.Queue) {
+	db, err := dbutil.NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	q := jobqueue.New(db, "test")
+	q.Backoff = func(attempts int) time.Duration {
+		return 0
+	}
+	require.NoError(t, q.Upgrade(context.Background()))
+	return db, q
+}
+
+func TestQueue_Claim(t *testing.T) {
+	db, q := initTestQueue(t)
+	ctx := context.Background()
+	low, err := q.Enqueue(ctx, []byte("low"), nil)
+	require.NoError(t, err)
+	high, err := q.Enqueue(ctx, []byte("high"), &jobqueue.EnqueueOptions{Priority: 10})
+	require.NoError(t, err)
+	_, err = q.Enqueue(ctx, []byte("later"), &jobqueue.EnqueueOptions{RunAt: time.Now().Add(time.Hour), Priority: 100})
+	require.NoError(t, err)
+	_, err = jobqueue.New(db, "other").Enqueue(ctx, []byte("other queue"), nil)
+	require.NoError(t, err)
+
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, high, job.ID)
+	assert.Equal(t, []byte("high"), job.Payload)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, jobqueue.JobStateRunning, job.State)
+	job2, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job2)
+	assert.Equal(t, low, job2.ID)
+	job3, err := q.Claim(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, job3)
+
+	require.NoError(t, q.Complete(ctx, job))
+	job, err = q.Get(ctx, high)
+	require.NoError(t, err)
+	assert.Nil(t, job)
+}
+
+func TestQueue_VisibilityTimeout(t *testing.T) {
+	_, q := initTestQueue(t)
+	ctx := context.Background()
+	q.VisibilityTimeout = -time.Second
+	id, err := q.Enqueue(ctx, nil, nil)
+	require.NoError(t, err)
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	reclaimed, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+	assert.Equal(t, id, reclaimed.ID)
+	assert.Equal(t, 2, reclaimed.Attempts)
+	assert.ErrorIs(t, q.Complete(ctx, job), jobqueue.ErrJobLost)
+	require.NoError(t, q.Complete(ctx, reclaimed))
+}
+
+func TestQueue_VisibilityTimeoutOnLastAttempt(t *testing.T) {
+	_, q := initTestQueue(t)
+	ctx := context.Background()
+	q.VisibilityTimeout = -time.Second
+	id, err := q.Enqueue(ctx, nil, &jobqueue.EnqueueOptions{MaxAttempts: 1})
+	require.NoError(t, err)
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	reclaimed, err := q.Claim(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, reclaimed)
+	failed, err := q.GetFailed(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, id, failed[0].ID)
+	assert.Equal(t, 1, failed[0].Attempts)
+	assert.ErrorIs(t, q.Complete(ctx, job), jobqueue.ErrJobLost)
+}
+
+func TestQueue_FailAndRequeue(t *testing.T) {
+	_, q := initTestQueue(t)
+	ctx := context.Background()
+	id, err := q.Enqueue(ctx, nil, &jobqueue.EnqueueOptions{MaxAttempts: 2})
+	require.NoError(t, err)
+	for i := 1; i <= 2; i++ {
+		job, err := q.Claim(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, i, job.Attempts)
+		require.NoError(t, q.Fail(ctx, job, errors.New("meow")))
+	}
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, job)
+	failed, err := q.GetFailed(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, id, failed[0].ID)
+	assert.Equal(t, jobqueue.JobStateFailed, failed[0].State)
+	assert.Equal(t, "meow", failed[0].LastError)
+
+	require.NoError(t, q.Requeue(ctx, id))
+	job, err = q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, 1, job.Attempts)
+}
+
+func TestQueue_EnqueueInTransaction(t *testing.T) {
+	db, q := initTestQueue(t)
+	ctx := context.Background()
+	err := db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := q.Enqueue(ctx, nil, nil)
+		require.NoError(t, err)
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, job)
+}
+
+func TestQueue_EnqueueAutoReadOnly(t *testing.T) {
+	db, err := dbutil.NewFromConfig("", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          "file:" + filepath.Join(t.TempDir(), "test.db") + "?_txlock=immediate",
+			MaxOpenConns: 1,
+			MaxIdleConns: 1,
+		},
+		ReadOnlyPool: dbutil.PoolConfig{
+			MaxOpenConns: 1,
+			MaxIdleConns: 1,
+		},
+		AutoReadOnly: true,
+	}, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	q := jobqueue.New(db, "test")
+	require.NoError(t, q.Upgrade(ctx))
+	id, err := q.Enqueue(ctx, []byte("meow"), nil)
+	require.NoError(t, err)
+	job, err := q.Claim(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, id, job.ID)
+}
+
+func TestQueue_Run(t *testing.T) {
+	_, q := initTestQueue(t)
+	q.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		_, err := q.Enqueue(ctx, []byte{byte(i)}, nil)
+		require.NoError(t, err)
+	}
+	var processed, failures atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, 3, func(ctx context.Context, job *jobqueue.Job) error {
+			if job.Payload[0]%3 == 0 && job.Attempts == 1 {
+				failures.Add(1)
+				panic("first attempt fails")
+			}
+			if processed.Add(1) == 10 {
+				cancel()
+			}
+			return nil
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queue didn't process all jobs in time")
+	}
+	assert.EqualValues(t, 10, processed.Load())
+	assert.EqualValues(t, 4, failures.Load())
+}
@@ -0,0 +1,19 @@
+-- v0 -> v1: Latest revision
+CREATE TABLE dbutil_jobs (
+	-- only: postgres
+	id           BIGINT  PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+	-- only: sqlite (line commented)
+--	id           INTEGER PRIMARY KEY,
+	queue        TEXT    NOT NULL,
+	payload      bytea   NOT NULL,
+	priority     INTEGER NOT NULL DEFAULT 0,
+	state        TEXT    NOT NULL DEFAULT 'pending',
+	run_at       BIGINT  NOT NULL,
+	locked_until BIGINT,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error   TEXT,
+	created_at   BIGINT  NOT NULL
+);
+
+CREATE INDEX dbutil_jobs_claim_idx ON dbutil_jobs (queue, state, run_at);
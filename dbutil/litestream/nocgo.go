@@ -1,3 +1,16 @@
 //go:build !cgo
 
 package litestream
+
+import (
+	"database/sql"
+
+	"go.mau.fi/util/dbutil/puresqlite"
+)
+
+// Without cgo, mattn/go-sqlite3 isn't available, so register the pure-Go equivalents under the standard names.
+func init() {
+	for name, drv := range puresqlite.Drivers {
+		sql.Register(name, drv)
+	}
+}
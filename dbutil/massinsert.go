@@ -7,8 +7,11 @@
 package dbutil
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -25,10 +28,13 @@ type MassInsertable[T Array] interface {
 	GetMassInsertValues() T
 }
 
+var ErrMassInsertNoReturning = errors.New("mass insert builder doesn't have RETURNING columns")
+
 // MassInsertBuilder contains pre-validated templates for building mass insert SQL queries.
 type MassInsertBuilder[Item MassInsertable[DynamicParams], StaticParams Array, DynamicParams Array] struct {
 	queryTemplate       string
 	placeholderTemplate string
+	returning           bool
 }
 
 // NewMassInsertBuilder creates a new MassInsertBuilder that can build mass insert database queries.
@@ -78,7 +84,16 @@ func NewMassInsertBuilder[Item MassInsertable[DynamicParams], StaticParams Array
 ) *MassInsertBuilder[Item, StaticParams, DynamicParams] {
 	var dyn DynamicParams
 	var stat StaticParams
-	totalParams := len(dyn) + len(stat)
+	return &MassInsertBuilder[Item, StaticParams, DynamicParams]{
+		queryTemplate:       parseMassInsertQuery(singleInsertQuery, placeholderTemplate, len(stat), len(dyn)),
+		placeholderTemplate: placeholderTemplate,
+	}
+}
+
+// parseMassInsertQuery is the non-generic implementation of [NewMassInsertBuilder].
+// It validates the query and placeholder template and returns the query template for [buildMassInsert].
+func parseMassInsertQuery(singleInsertQuery, placeholderTemplate string, staticCount, dynamicCount int) string {
+	totalParams := dynamicCount + staticCount
 	mainQueryVariablePlaceholderParts := make([]string, totalParams)
 	for i := 0; i < totalParams; i++ {
 		mainQueryVariablePlaceholderParts[i] = fmt.Sprintf(`\$%d`, i+1)
@@ -90,37 +105,35 @@ func NewMassInsertBuilder[Item MassInsertable[DynamicParams], StaticParams Array
 	} else if len(queryPlaceholders) > 1 {
 		panic(fmt.Errorf("invalid insert query: multiple placeholders found"))
 	}
-	for i := 0; i < len(stat); i++ {
+	for i := 0; i < staticCount; i++ {
 		if !strings.Contains(placeholderTemplate, fmt.Sprintf("$%d", i+1)) {
 			panic(fmt.Errorf("invalid placeholder template: static placeholder $%d not found", i+1))
 		}
 	}
-	if strings.Contains(placeholderTemplate, fmt.Sprintf("$%d", len(stat)+1)) {
-		panic(fmt.Errorf("invalid placeholder template: non-static placeholder $%d found", len(stat)+1))
+	if strings.Contains(placeholderTemplate, fmt.Sprintf("$%d", staticCount+1)) {
+		panic(fmt.Errorf("invalid placeholder template: non-static placeholder $%d found", staticCount+1))
 	}
-	fmtParams := make([]any, len(dyn))
-	for i := 0; i < len(dyn); i++ {
-		fmtParams[i] = fmt.Sprintf("$%d", len(stat)+i+1)
+	fmtParams := make([]any, dynamicCount)
+	for i := 0; i < dynamicCount; i++ {
+		fmtParams[i] = fmt.Sprintf("$%d", staticCount+i+1)
 	}
 	formattedPlaceholder := fmt.Sprintf(placeholderTemplate, fmtParams...)
 	if strings.Contains(formattedPlaceholder, "!(EXTRA string=") {
 		panic(fmt.Errorf("invalid placeholder template: extra string found"))
 	}
-	for i := 0; i < len(dyn); i++ {
-		if !strings.Contains(formattedPlaceholder, fmt.Sprintf("$%d", len(stat)+i+1)) {
-			panic(fmt.Errorf("invalid placeholder template: dynamic placeholder $%d not found", len(stat)+i+1))
+	for i := 0; i < dynamicCount; i++ {
+		if !strings.Contains(formattedPlaceholder, fmt.Sprintf("$%d", staticCount+i+1)) {
+			panic(fmt.Errorf("invalid placeholder template: dynamic placeholder $%d not found", staticCount+i+1))
 		}
 	}
-	return &MassInsertBuilder[Item, StaticParams, DynamicParams]{
-		queryTemplate:       strings.Replace(singleInsertQuery, queryPlaceholders[0], "%s", 1),
-		placeholderTemplate: placeholderTemplate,
-	}
+	return strings.Replace(singleInsertQuery, queryPlaceholders[0], "%s", 1)
 }
 
 // Build constructs a ready-to-use mass insert SQL query using the prepared templates in this builder.
 //
 // This method always only produces one query. If there are lots of items,
 // chunking them beforehand may be required to avoid query parameter limits.
+// [MassInsertBuilder.Exec] can be used to do the chunking automatically.
 // For example, SQLite (3.32+) has a limit of 32766 parameters by default,
 // while Postgres allows up to 65535. To find out if there are too many items,
 // divide the maximum number of parameters by the number of dynamic columns in
@@ -144,21 +157,168 @@ func NewMassInsertBuilder[Item MassInsertable[DynamicParams], StaticParams Array
 //	}
 func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) Build(static StaticParams, data []Item) (query string, params []any) {
 	var itemValues DynamicParams
-	params = make([]any, len(static)+len(itemValues)*len(data))
-	placeholders := make([]string, len(data))
+	staticParams := make([]any, len(static))
 	for i := 0; i < len(static); i++ {
-		params[i] = static[i]
+		staticParams[i] = static[i]
 	}
-	fmtParams := make([]any, len(itemValues))
-	for i, item := range data {
-		baseIndex := len(static) + len(itemValues)*i
-		itemValues = item.GetMassInsertValues()
+	return buildMassInsert(mib.queryTemplate, mib.placeholderTemplate, staticParams, len(itemValues), len(data), func(i int, into []any) {
+		itemValues = data[i].GetMassInsertValues()
 		for j := 0; j < len(itemValues); j++ {
-			params[baseIndex+j] = itemValues[j]
+			into[j] = itemValues[j]
+		}
+	})
+}
+
+// buildMassInsert is the non-generic implementation of [MassInsertBuilder.Build].
+// The getValues function must fill the given slice with the dynamic parameters of the ith item.
+func buildMassInsert(
+	queryTemplate, placeholderTemplate string, static []any, dynamicCount, itemCount int, getValues func(i int, into []any),
+) (query string, params []any) {
+	params = make([]any, len(static)+dynamicCount*itemCount)
+	placeholders := make([]string, itemCount)
+	copy(params, static)
+	fmtParams := make([]any, dynamicCount)
+	for i := 0; i < itemCount; i++ {
+		baseIndex := len(static) + dynamicCount*i
+		getValues(i, params[baseIndex:baseIndex+dynamicCount])
+		for j := 0; j < dynamicCount; j++ {
 			fmtParams[j] = baseIndex + j + 1
 		}
-		placeholders[i] = fmt.Sprintf(mib.placeholderTemplate, fmtParams...)
+		placeholders[i] = fmt.Sprintf(placeholderTemplate, fmtParams...)
 	}
-	query = fmt.Sprintf(mib.queryTemplate, strings.Join(placeholders, ", "))
+	query = fmt.Sprintf(queryTemplate, strings.Join(placeholders, ", "))
 	return
 }
+
+// maxQueryParams returns the maximum number of parameters a single query can have in the given dialect.
+func maxQueryParams(dialect Dialect) int {
+	if dialect == Postgres {
+		return 65535
+	}
+	// SQLite 3.32+ default
+	return 32766
+}
+
+var insertColumnsRegex = regexp.MustCompile(`(?i)^\s*INSERT\s+INTO\s+\S+\s*\(([^)]*)\)`)
+
+// WithUpsert returns a copy of the builder with an ON CONFLICT clause that updates all
+// columns other than the given conflict columns. If all columns are conflict columns,
+// conflicting rows are ignored instead.
+//
+// The column names are read from the insert query, which must list them explicitly.
+// Like [NewMassInsertBuilder], this will panic if the query is invalid.
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) WithUpsert(conflictColumns ...string) *MassInsertBuilder[Item, StaticParams, DynamicParams] {
+	match := insertColumnsRegex.FindStringSubmatch(mib.queryTemplate)
+	if match == nil {
+		panic(fmt.Errorf("invalid insert query: column list not found"))
+	} else if len(conflictColumns) == 0 {
+		panic(fmt.Errorf("no conflict columns specified for upsert"))
+	}
+	var updates []string
+	for _, col := range strings.Split(match[1], ",") {
+		col = strings.TrimSpace(col)
+		isConflictColumn := false
+		for _, conflictCol := range conflictColumns {
+			if strings.EqualFold(strings.Trim(col, `"`), strings.Trim(conflictCol, `"`)) {
+				isConflictColumn = true
+				break
+			}
+		}
+		if !isConflictColumn {
+			updates = append(updates, fmt.Sprintf("%s=excluded.%s", col, col))
+		}
+	}
+	clause := fmt.Sprintf(" ON CONFLICT (%s) DO ", strings.Join(conflictColumns, ", "))
+	if len(updates) == 0 {
+		clause += "NOTHING"
+	} else {
+		clause += "UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return mib.withSuffix(clause)
+}
+
+// WithReturning returns a copy of the builder with a RETURNING clause for the given columns.
+// The returned rows can be read using [MassInsertBuilder.ExecReturning].
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) WithReturning(columns ...string) *MassInsertBuilder[Item, StaticParams, DynamicParams] {
+	if len(columns) == 0 {
+		panic(fmt.Errorf("no columns specified for returning"))
+	}
+	newMIB := mib.withSuffix(" RETURNING " + strings.Join(columns, ", "))
+	newMIB.returning = true
+	return newMIB
+}
+
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) withSuffix(suffix string) *MassInsertBuilder[Item, StaticParams, DynamicParams] {
+	newMIB := *mib
+	newMIB.queryTemplate = strings.TrimRight(strings.TrimSpace(mib.queryTemplate), ";") + suffix
+	return &newMIB
+}
+
+// ChunkSize returns the maximum number of items that can be inserted with a single query
+// without exceeding the parameter limit of the given dialect.
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) ChunkSize(dialect Dialect) int {
+	var dyn DynamicParams
+	var stat StaticParams
+	return massInsertChunkSize(dialect, len(stat), len(dyn))
+}
+
+// massInsertChunkSize is the non-generic implementation of [MassInsertBuilder.ChunkSize].
+func massInsertChunkSize(dialect Dialect, staticCount, dynamicCount int) int {
+	if dynamicCount == 0 {
+		return 1
+	}
+	return (maxQueryParams(dialect) - staticCount) / dynamicCount
+}
+
+// Exec inserts all the given items into the database. The items are automatically split into chunks
+// that fit in the parameter limit of the database (see [MassInsertBuilder.ChunkSize]), and all chunks
+// are inserted in a single transaction.
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) Exec(ctx context.Context, db *Database, static StaticParams, data []Item) error {
+	return mib.exec(ctx, db, static, data, nil)
+}
+
+// ExecReturning is like [MassInsertBuilder.Exec], but it calls the given function for each row returned
+// by the RETURNING clause (see [MassInsertBuilder.WithReturning]).
+//
+// Neither SQLite nor Postgres guarantee that RETURNING produces rows in the same order as the VALUES
+// list, so the rows aren't matched to items. The returned columns should include something that
+// identifies the item (e.g. the conflict columns of an upsert), which the function can use to find
+// the corresponding item. Rows skipped with ON CONFLICT DO NOTHING aren't returned at all.
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) ExecReturning(
+	ctx context.Context, db *Database, static StaticParams, data []Item, fn func(row Scannable) error,
+) error {
+	if !mib.returning {
+		return ErrMassInsertNoReturning
+	}
+	return mib.exec(ctx, db, static, data, fn)
+}
+
+func (mib *MassInsertBuilder[Item, StaticParams, DynamicParams]) exec(
+	ctx context.Context, db *Database, static StaticParams, data []Item, returningFn func(row Scannable) error,
+) error {
+	if len(data) == 0 {
+		return nil
+	}
+	chunkSize := mib.ChunkSize(db.Dialect)
+	return db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for chunk := range slices.Chunk(data, chunkSize) {
+			query, params := mib.Build(static, chunk)
+			if returningFn == nil {
+				if _, err := db.Exec(ctx, query, params...); err != nil {
+					return err
+				}
+				continue
+			}
+			rows, err := db.Query(ctx, query, params...)
+			err = NewRowIterWithError(rows, func(row Scannable) (struct{}, error) {
+				return struct{}{}, returningFn(row)
+			}, err).Iter(func(struct{}) (bool, error) {
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -7,13 +7,16 @@
 package dbutil_test
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/random"
@@ -130,3 +133,107 @@ func BenchmarkMassInsertBuilder_Build5x100_Manual(b *testing.B) {
 		buildMassInsertManual(data)
 	}
 }
+
+type massInsertMeow struct {
+	ID    int
+	Value string
+}
+
+func (m *massInsertMeow) GetMassInsertValues() [2]any {
+	return [2]any{m.ID, m.Value}
+}
+
+var massInsertMeowBuilder = dbutil.NewMassInsertBuilder[*massInsertMeow, [0]any](
+	"INSERT INTO meow (id, value) VALUES ($1, $2)", "($%d, $%d)",
+)
+
+func TestMassInsertBuilder_WithUpsert(t *testing.T) {
+	query, _ := massInsertMeowBuilder.WithUpsert("id").Build([0]any{}, []*massInsertMeow{{ID: 1}})
+	assert.Equal(t, "INSERT INTO meow (id, value) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET value=excluded.value", query)
+	query, _ = massInsertMeowBuilder.WithUpsert("id", "value").WithReturning("id").Build([0]any{}, []*massInsertMeow{{ID: 1}})
+	assert.Equal(t, "INSERT INTO meow (id, value) VALUES ($1, $2) ON CONFLICT (id, value) DO NOTHING RETURNING id", query)
+	assert.PanicsWithError(t, "invalid insert query: column list not found", func() {
+		dbutil.NewMassInsertBuilder[OneParamMassInsertable, [1]any]("INSERT INTO foo VALUES ($1, $2)", "($1, $%d)").WithUpsert("a")
+	})
+}
+
+func TestMassInsertBuilder_ChunkSize(t *testing.T) {
+	assert.Equal(t, 16383, massInsertMeowBuilder.ChunkSize(dbutil.SQLite))
+	assert.Equal(t, 32767, massInsertMeowBuilder.ChunkSize(dbutil.Postgres))
+	builder := dbutil.NewMassInsertBuilder[AbstractMassInsertable[[5]any], [3]any]("INSERT INTO foo VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", "($1, $2, $%d, $%d, $3, $%d, $%d, $%d)")
+	assert.Equal(t, 6552, builder.ChunkSize(dbutil.SQLite))
+}
+
+func TestMassInsertBuilder_Exec(t *testing.T) {
+	db := initTestDB(t)
+	ctx := context.Background()
+	data := make([]*massInsertMeow, 20000)
+	for i := range data {
+		data[i] = &massInsertMeow{ID: i + 10, Value: "meow"}
+	}
+	var countBefore, countAfter int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM meow").Scan(&countBefore))
+	require.NoError(t, massInsertMeowBuilder.Exec(ctx, db, [0]any{}, data))
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM meow").Scan(&countAfter))
+	assert.Equal(t, countBefore+len(data), countAfter)
+
+	upserted := []*massInsertMeow{{ID: 1, Value: "hmm"}, {ID: 5, Value: "new"}}
+	returned := make(map[int]string)
+	err := massInsertMeowBuilder.WithUpsert("id").WithReturning("id", "value").ExecReturning(ctx, db, [0]any{}, upserted, func(row dbutil.Scannable) error {
+		var id int
+		var value string
+		err := row.Scan(&id, &value)
+		returned[id] = value
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "hmm", 5: "new"}, returned)
+	var value string
+	require.NoError(t, db.QueryRow(ctx, "SELECT value FROM meow WHERE id=1").Scan(&value))
+	assert.Equal(t, "hmm", value)
+
+	ignoreBuilder := dbutil.NewMassInsertBuilder[*massInsertMeow, [0]any](
+		"INSERT INTO meow (id, value) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING", "($%d, $%d)",
+	)
+	clear(returned)
+	err = ignoreBuilder.WithReturning("id", "value").ExecReturning(ctx, db, [0]any{}, []*massInsertMeow{{ID: 5, Value: "skipped"}, {ID: 6, Value: "new"}}, func(row dbutil.Scannable) error {
+		var id int
+		var value string
+		err := row.Scan(&id, &value)
+		returned[id] = value
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{6: "new"}, returned)
+
+	err = massInsertMeowBuilder.ExecReturning(ctx, db, [0]any{}, upserted, func(row dbutil.Scannable) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, dbutil.ErrMassInsertNoReturning)
+}
+
+func TestMassInsertBuilder_ExecReturning_Order(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	db, err := dbutil.NewWithDB(conn, "postgres")
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	// The database is free to return rows in a different order than they were inserted in
+	mock.ExpectQuery("INSERT INTO meow (id, value) VALUES ($1, $2), ($3, $4) ON CONFLICT (id) DO UPDATE SET value=excluded.value RETURNING id, value").
+		WithArgs(1, "foo", 2, "bar").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow(2, "bar").AddRow(1, "foo"))
+	mock.ExpectCommit()
+
+	items := []*massInsertMeow{{ID: 1, Value: "foo"}, {ID: 2, Value: "bar"}}
+	var returnedIDs []int
+	err = massInsertMeowBuilder.WithUpsert("id").WithReturning("id", "value").ExecReturning(context.Background(), db, [0]any{}, items, func(row dbutil.Scannable) error {
+		var id int
+		var value string
+		err := row.Scan(&id, &value)
+		returnedIDs = append(returnedIDs, id)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, returnedIDs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
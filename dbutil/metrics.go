@@ -0,0 +1,58 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MetricsCollector receives metrics about queries and transactions.
+// It can be set in [Database.Metrics] to collect metrics in addition to logging with [DatabaseLogger].
+//
+// See [PrometheusMetrics] for a built-in implementation.
+type MetricsCollector interface {
+	// ObserveQuery is called after every database call. The method is the same as in
+	// [DatabaseLogger.QueryTiming] (Exec, Query, QueryRow, EndRows, Begin, Commit or Rollback)
+	// and the query is normalized using [NormalizeQuery]. The number of rows is only set for
+	// the EndRows method, which is called when a result set has been fully read, and is -1 otherwise.
+	ObserveQuery(ctx context.Context, method, query string, nrows int, duration time.Duration, err error)
+	// ObserveTransaction is called after a transaction is committed or rolled back.
+	ObserveTransaction(ctx context.Context, duration time.Duration, committed bool)
+}
+
+var (
+	placeholderRegex       = regexp.MustCompile(`[$?]\d+|\?`)
+	repeatedValuesRegex    = regexp.MustCompile(`(\([^()]*\))(?:\s*,\s*\([^()]*\))+`)
+	placeholderInListRegex = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+)
+
+// NormalizeQuery normalizes a query for use as a metric label, so that the same query with
+// different parameters produces the same label. Whitespace is collapsed, all placeholders are
+// replaced with ?, lists of placeholders in IN clauses are collapsed to IN (...) and multiple
+// VALUES tuples (e.g. from [MassInsertBuilder]) are collapsed to the first tuple.
+func NormalizeQuery(query string) string {
+	query = strings.TrimSpace(whitespaceRegex.ReplaceAllLiteralString(query, " "))
+	query = placeholderRegex.ReplaceAllLiteralString(query, "?")
+	query = placeholderInListRegex.ReplaceAllLiteralString(query, "IN (...)")
+	query = repeatedValuesRegex.ReplaceAllString(query, "$1, ...")
+	return query
+}
+
+func (db *Database) observeQuery(ctx context.Context, method, query string, nrows int, duration time.Duration, err error) {
+	if db.Metrics != nil {
+		db.Metrics.ObserveQuery(ctx, method, NormalizeQuery(query), nrows, duration, err)
+	}
+}
+
+func (db *Database) observeTransaction(ctx context.Context, duration time.Duration, committed bool) {
+	if db.Metrics != nil {
+		db.Metrics.ObserveTransaction(ctx, duration, committed)
+	}
+}
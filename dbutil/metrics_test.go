@@ -0,0 +1,63 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM foo WHERE a=$1 AND b=?2":                      "SELECT * FROM foo WHERE a=? AND b=?",
+		"SELECT *\n\tFROM foo\n\tWHERE id IN ($1, $2,$3)":            "SELECT * FROM foo WHERE id IN (...)",
+		"INSERT INTO foo (a, b) VALUES ($1, $2), ($1, $3), ($1, $4)": "INSERT INTO foo (a, b) VALUES (?, ?), ...",
+		"INSERT INTO foo (a, b) VALUES ($1, $2)":                     "INSERT INTO foo (a, b) VALUES (?, ?)",
+		"SELECT COALESCE(a, b), MAX(c) FROM foo":                     "SELECT COALESCE(a, b), MAX(c) FROM foo",
+	}
+	for input, expected := range cases {
+		assert.Equal(t, expected, NormalizeQuery(input), input)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	metrics := NewPrometheusMetrics()
+	metrics.Buckets = []float64{10}
+	metrics.AddDatabase("main", db)
+	db.Metrics = metrics
+	ctx := context.Background()
+
+	_, err = db.Exec(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := db.Exec(ctx, "INSERT INTO foo (id) VALUES ($1), ($2)", 1, 2)
+		return err
+	})
+	require.NoError(t, err)
+	_ = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		return errors.New("meow")
+	})
+	rows, err := db.Query(ctx, "SELECT id FROM foo")
+	require.NoError(t, err)
+	_, err = ConvertRowFn[int](ScanSingleColumn[int]).NewRowIter(rows, err).AsList()
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "SELECT * FROM nonexistent")
+	require.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	output := rec.Body.String()
+	assert.Contains(t, output, `dbutil_query_duration_seconds_count{method="Exec",query="INSERT INTO foo (id) VALUES (?), ..."} 1`)
+	assert.Contains(t, output, `dbutil_query_duration_seconds_bucket{method="Begin",query="",le="+Inf"} 2`)
+	assert.Contains(t, output, `dbutil_query_errors_total{method="Exec",query="SELECT * FROM nonexistent"} 1`)
+	assert.Contains(t, output, `dbutil_query_rows_total{query="SELECT id FROM foo"} 2`)
+	assert.Contains(t, output, `dbutil_transaction_duration_seconds_count{result="commit"} 1`)
+	assert.Contains(t, output, `dbutil_transaction_duration_seconds_bucket{result="rollback",le="10"} 1`)
+	assert.Contains(t, output, `dbutil_pool_max_open_connections{pool="main"} 1`)
+}
@@ -116,4 +116,21 @@
 // If the filter ends with `(lines commented)`, then ALL lines chosen
 // by the filter will be uncommented. The `--` comment prefix must be
 // at the beginning of the line with no whitespace ahead of it.
+//
+// Upgrade files can also call Go functions registered with
+// WithFSAndHooks by adding `-- run: name` lines after the flags. The
+// functions are called after the SQL in the file has been executed:
+//
+//	-- v6: Migrate legacy JSON
+//	-- run: migrateLegacyJSON
+//
+// Files ending in `.down.sql` are downgrades, which are used by
+// Database.Downgrade to revert the schema to an older version. They
+// use the same header format as upgrades, but the source version is
+// mandatory and must be newer than the target version:
+//
+//	-- v5 -> v4: Revert doing things
+//
+// Dialect filters and the transaction flag work in downgrades too.
+// Only one downgrade can target each version.
 package dbutil
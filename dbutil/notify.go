@@ -0,0 +1,360 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Notification is a single notification received by a [Listener].
+type Notification struct {
+	Channel string
+	Payload string
+	// Reconnected is set (with an empty payload) when the backend had to reconnect to the database,
+	// which means that notifications sent while it was disconnected may have been lost.
+	// Such notifications are delivered to all subscribers.
+	Reconnected bool
+}
+
+// ListenerBackend is the database-specific part of a [Listener].
+//
+// On SQLite, [NewSQLiteListenerBackend] can be used. On Postgres, the backend must be implemented
+// using a driver-specific listener, e.g. the one in the go.mau.fi/util/dbutil/pqlisten package.
+type ListenerBackend interface {
+	// Listen starts listening to the given channel.
+	Listen(ctx context.Context, channel string) error
+	// Unlisten stops listening to the given channel.
+	Unlisten(ctx context.Context, channel string) error
+	// Run receives notifications and passes them to the deliver function until the context is canceled.
+	// The backend is responsible for reconnecting if the connection to the database is lost,
+	// and should deliver a notification with Reconnected set if notifications may have been lost.
+	Run(ctx context.Context, deliver func(Notification)) error
+}
+
+var (
+	ErrListenerNoBackend        = errors.New("no listener backend available for this database")
+	ErrListenerSingleConnection = errors.New("SQLite listener needs a read-only pool or more than one connection in the main pool")
+)
+
+// Listener delivers notifications sent with [Database.Notify] to Go channels.
+type Listener struct {
+	db      *Database
+	backend ListenerBackend
+
+	lock   sync.Mutex
+	subs   map[string][]chan Notification
+	closed bool
+}
+
+// NewListener creates a new notification listener for the database. If the backend is nil,
+// the SQLite polling backend is used on SQLite, while Postgres will return an error.
+//
+// [Listener.Run] must be called to actually receive notifications.
+func (db *Database) NewListener(backend ListenerBackend) (*Listener, error) {
+	if backend == nil {
+		if db.Dialect != SQLite {
+			return nil, ErrListenerNoBackend
+		}
+		backend = NewSQLiteListenerBackend(db, 0)
+	}
+	return &Listener{
+		db:      db,
+		backend: backend,
+		subs:    make(map[string][]chan Notification),
+	}, nil
+}
+
+// Subscribe returns a Go channel that receives notifications sent to the given database channel.
+//
+// Notifications are delivered without blocking, so if the Go channel buffer is full,
+// the notification is dropped and a warning is logged. The returned function must be
+// called to unsubscribe, after which the Go channel is closed.
+func (l *Listener) Subscribe(ctx context.Context, channel string, bufferSize int) (<-chan Notification, func(), error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil, nil, fmt.Errorf("listener is closed")
+	}
+	if len(l.subs[channel]) == 0 {
+		if err := l.backend.Listen(ctx, channel); err != nil {
+			return nil, nil, fmt.Errorf("failed to listen to %s: %w", channel, err)
+		}
+	}
+	ch := make(chan Notification, bufferSize)
+	l.subs[channel] = append(l.subs[channel], ch)
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			l.unsubscribe(ctx, channel, ch)
+		})
+	}, nil
+}
+
+func (l *Listener) unsubscribe(ctx context.Context, channel string, ch chan Notification) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	subs := l.subs[channel]
+	for i, sub := range subs {
+		if sub == ch {
+			l.subs[channel] = append(subs[:i:i], subs[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+	if len(l.subs[channel]) == 0 {
+		delete(l.subs, channel)
+		if !l.closed {
+			if err := l.backend.Unlisten(ctx, channel); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("channel", channel).Msg("Failed to stop listening to channel")
+			}
+		}
+	}
+}
+
+func (l *Listener) deliver(ctx context.Context, notif Notification) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if notif.Reconnected {
+		for channel, subs := range l.subs {
+			notif.Channel = channel
+			deliverToSubscribers(ctx, subs, notif)
+		}
+	} else {
+		deliverToSubscribers(ctx, l.subs[notif.Channel], notif)
+	}
+}
+
+func deliverToSubscribers(ctx context.Context, subs []chan Notification, notif Notification) {
+	for _, ch := range subs {
+		select {
+		case ch <- notif:
+		default:
+			zerolog.Ctx(ctx).Warn().
+				Str("channel", notif.Channel).
+				Msg("Dropping database notification as subscriber channel is full")
+		}
+	}
+}
+
+// Run receives notifications until the context is canceled. After Run returns,
+// all subscriber channels are closed and the listener can't be used anymore.
+func (l *Listener) Run(ctx context.Context) error {
+	err := l.backend.Run(ctx, func(notif Notification) {
+		l.deliver(ctx, notif)
+	})
+	l.lock.Lock()
+	l.closed = true
+	for _, subs := range l.subs {
+		for _, ch := range subs {
+			close(ch)
+		}
+	}
+	clear(l.subs)
+	l.lock.Unlock()
+	return err
+}
+
+// sqliteNotificationTable is the table used by [Database.Notify] on SQLite. It's created on demand.
+const sqliteNotificationTable = "dbutil_notifications"
+
+const createSQLiteNotificationTable = `
+CREATE TABLE IF NOT EXISTS ` + sqliteNotificationTable + ` (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel    TEXT    NOT NULL,
+	payload    TEXT    NOT NULL,
+	created_at BIGINT  NOT NULL
+)`
+
+// Notify sends a notification to all listeners of the given channel, including ones in other processes.
+//
+// On Postgres, this uses NOTIFY. On SQLite, the notification is stored in a table that listeners poll.
+// In both cases, if the context has a transaction, the notification is only delivered after it's committed.
+func (db *Database) Notify(ctx context.Context, channel, payload string) error {
+	switch db.Dialect {
+	case Postgres:
+		_, err := db.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+		return err
+	case SQLite:
+		if !db.notificationTableCreated.Load() {
+			if _, err := db.Exec(ctx, createSQLiteNotificationTable); err != nil {
+				return fmt.Errorf("failed to create notification table: %w", err)
+			}
+			// If the table was created inside a transaction, it'll be gone if the transaction is rolled back
+			if ctx.Value(db.txnCtxKey) == nil {
+				db.notificationTableCreated.Store(true)
+			}
+		}
+		_, err := db.Exec(
+			ctx, "INSERT INTO "+sqliteNotificationTable+" (channel, payload, created_at) VALUES ($1, $2, $3)",
+			channel, payload, time.Now().UnixMilli(),
+		)
+		return err
+	default:
+		return ErrUnsupportedDialect
+	}
+}
+
+const (
+	defaultSQLitePollInterval        = 250 * time.Millisecond
+	sqliteNotificationRetention      = 10 * time.Minute
+	sqliteNotificationCleanupEvery   = time.Minute
+	sqliteNotificationReconnectDelay = 5 * time.Second
+)
+
+type sqliteListenerBackend struct {
+	db       *Database
+	interval time.Duration
+
+	lock     sync.RWMutex
+	channels map[string]struct{}
+}
+
+// NewSQLiteListenerBackend creates a [ListenerBackend] that polls the notification table used by
+// [Database.Notify] on SQLite. If the interval is zero, a default of 250ms is used.
+//
+// To avoid reading the table on every poll, the backend holds a dedicated connection and checks
+// the data_version pragma, which only changes when another connection modifies the database.
+// The connection is taken from the read-only pool if one is configured. Otherwise, it's permanently
+// taken from the main pool, so running the backend fails with [ErrListenerSingleConnection] if the
+// main pool is limited to a single connection, as that would block all other queries.
+func NewSQLiteListenerBackend(db *Database, interval time.Duration) ListenerBackend {
+	if interval <= 0 {
+		interval = defaultSQLitePollInterval
+	}
+	return &sqliteListenerBackend{
+		db:       db,
+		interval: interval,
+		channels: make(map[string]struct{}),
+	}
+}
+
+func (slb *sqliteListenerBackend) Listen(_ context.Context, channel string) error {
+	slb.lock.Lock()
+	slb.channels[channel] = struct{}{}
+	slb.lock.Unlock()
+	return nil
+}
+
+func (slb *sqliteListenerBackend) Unlisten(_ context.Context, channel string) error {
+	slb.lock.Lock()
+	delete(slb.channels, channel)
+	slb.lock.Unlock()
+	return nil
+}
+
+func (slb *sqliteListenerBackend) isListening(channel string) bool {
+	slb.lock.RLock()
+	defer slb.lock.RUnlock()
+	_, ok := slb.channels[channel]
+	return ok
+}
+
+type sqlitePollConn struct {
+	*LoggingExecable
+	close   func() error
+	version int64
+}
+
+func (slb *sqliteListenerBackend) connect(ctx context.Context) (*sqlitePollConn, error) {
+	pool := slb.db.RawDB
+	if slb.db.ReadOnlyDB != nil {
+		pool = slb.db.ReadOnlyDB
+	}
+	rawConn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlitePollConn{
+		LoggingExecable: &LoggingExecable{UnderlyingExecable: rawConn, db: slb.db},
+		close:           rawConn.Close,
+		version:         -1,
+	}, nil
+}
+
+func (slb *sqliteListenerBackend) poll(ctx context.Context, conn *sqlitePollConn, lastID *int64, deliver func(Notification)) error {
+	var version int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to get data version: %w", err)
+	} else if version == conn.version {
+		return nil
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT id, channel, payload FROM "+sqliteNotificationTable+" WHERE id>$1 ORDER BY id", *lastID)
+	err = NewRowIterWithError(rows, func(row Scannable) (notif Notification, err error) {
+		err = row.Scan(lastID, &notif.Channel, &notif.Payload)
+		return
+	}, err).Iter(func(notif Notification) (bool, error) {
+		if slb.isListening(notif.Channel) {
+			deliver(notif)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read notifications: %w", err)
+	}
+	conn.version = version
+	return nil
+}
+
+func (slb *sqliteListenerBackend) Run(ctx context.Context, deliver func(Notification)) error {
+	log := zerolog.Ctx(ctx)
+	if slb.db.ReadOnlyDB == nil && slb.db.RawDB.Stats().MaxOpenConnections == 1 {
+		return ErrListenerSingleConnection
+	}
+	if _, err := slb.db.Exec(ctx, createSQLiteNotificationTable); err != nil {
+		return fmt.Errorf("failed to create notification table: %w", err)
+	}
+	var lastID int64
+	if err := slb.db.QueryRow(ctx, "SELECT COALESCE(MAX(id), 0) FROM "+sqliteNotificationTable).Scan(&lastID); err != nil {
+		return fmt.Errorf("failed to get latest notification ID: %w", err)
+	}
+	var conn *sqlitePollConn
+	defer func() {
+		if conn != nil {
+			_ = conn.close()
+		}
+	}()
+	ticker := time.NewTicker(slb.interval)
+	defer ticker.Stop()
+	var lastCleanup, nextConnect time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if conn == nil {
+			if time.Now().Before(nextConnect) {
+				continue
+			}
+			var err error
+			if conn, err = slb.connect(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to acquire connection for polling notifications")
+				nextConnect = time.Now().Add(sqliteNotificationReconnectDelay)
+				continue
+			}
+		}
+		if err := slb.poll(ctx, conn, &lastID, deliver); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Failed to poll notifications, reconnecting")
+			_ = conn.close()
+			conn = nil
+			nextConnect = time.Now().Add(sqliteNotificationReconnectDelay)
+		}
+		if time.Since(lastCleanup) > sqliteNotificationCleanupEvery {
+			lastCleanup = time.Now()
+			_, err := slb.db.Exec(ctx, "DELETE FROM "+sqliteNotificationTable+" WHERE created_at<$1", time.Now().Add(-sqliteNotificationRetention).UnixMilli())
+			if err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("Failed to delete old notifications")
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener_SQLite(t *testing.T) {
+	db, err := NewWithDialect("file:"+filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := db.NewListener(NewSQLiteListenerBackend(db, 10*time.Millisecond))
+	require.NoError(t, err)
+	ch, unsubscribe, err := listener.Subscribe(ctx, "meow", 10)
+	require.NoError(t, err)
+	require.NoError(t, db.Notify(ctx, "meow", "before run"))
+	runDone := make(chan error)
+	go func() {
+		runDone <- listener.Run(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, db.Notify(ctx, "hmm", "other channel"))
+	require.NoError(t, db.Notify(ctx, "meow", "hello"))
+	require.NoError(t, db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		return db.Notify(ctx, "meow", "from txn")
+	}))
+	for _, expected := range []string{"hello", "from txn"} {
+		select {
+		case notif := <-ch:
+			assert.Equal(t, Notification{Channel: "meow", Payload: expected}, notif)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Didn't receive notification %q", expected)
+		}
+	}
+
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	ch2, _, err := listener.Subscribe(ctx, "meow", 1)
+	require.NoError(t, err)
+	cancel()
+	require.NoError(t, <-runDone)
+	_, ok = <-ch2
+	assert.False(t, ok)
+}
+
+func TestDatabase_NewListener_PostgresNeedsBackend(t *testing.T) {
+	db := &Database{Dialect: Postgres}
+	_, err := db.NewListener(nil)
+	assert.ErrorIs(t, err, ErrListenerNoBackend)
+}
+
+func TestDatabase_Notify_RolledBackTableCreation(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	errRollback := errors.New("rollback")
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		require.NoError(t, db.Notify(ctx, "meow", "rolled back"))
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	require.NoError(t, db.Notify(ctx, "meow", "hello"))
+
+	schema, err := db.DumpSchema(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, schema.Table("dbutil_notifications"))
+
+	listener, err := db.NewListener(nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, listener.Run(ctx), ErrListenerSingleConnection)
+}
+
+type fakeListenerBackend struct {
+	notifs chan Notification
+}
+
+func (flb *fakeListenerBackend) Listen(context.Context, string) error   { return nil }
+func (flb *fakeListenerBackend) Unlisten(context.Context, string) error { return nil }
+
+func (flb *fakeListenerBackend) Run(ctx context.Context, deliver func(Notification)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notif := <-flb.notifs:
+			deliver(notif)
+		}
+	}
+}
+
+func TestListener_Reconnected(t *testing.T) {
+	backend := &fakeListenerBackend{notifs: make(chan Notification)}
+	listener, err := (&Database{Dialect: Postgres}).NewListener(backend)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	meow, _, err := listener.Subscribe(ctx, "meow", 1)
+	require.NoError(t, err)
+	hmm, _, err := listener.Subscribe(ctx, "hmm", 1)
+	require.NoError(t, err)
+	go listener.Run(ctx)
+	backend.notifs <- Notification{Reconnected: true}
+	assert.Equal(t, Notification{Channel: "meow", Reconnected: true}, <-meow)
+	assert.Equal(t, Notification{Channel: "hmm", Reconnected: true}, <-hmm)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const defaultPageSize = 1000
+
+// KeysetPagination iterates over the results of a query one page at a time using keyset pagination
+// (i.e. `WHERE (key1, key2) > (last1, last2) ORDER BY key1, key2 LIMIT n`) instead of keeping a single
+// cursor open for the whole iteration.
+//
+// Example:
+//
+//	var messagePaginator = dbutil.KeysetPagination[*Message]{
+//		Query:      "SELECT room_id, timestamp, id, body FROM message WHERE room_id=$1",
+//		KeyColumns: []string{"timestamp", "id"},
+//		GetKey: func(msg *Message) []any {
+//			return []any{msg.Timestamp, msg.ID}
+//		},
+//		ConvertRow: scanMessage,
+//	}
+//
+//	func (mq *MessageQuery) IterAll(ctx context.Context, roomID string) dbutil.RowIter[*Message] {
+//		return messagePaginator.Iter(ctx, mq.db, roomID)
+//	}
+type KeysetPagination[T any] struct {
+	// Query is the base SELECT query. It must not have ORDER BY or LIMIT clauses, and it must
+	// select all the key columns. It's wrapped in a subquery, so it may have any WHERE clauses.
+	Query string
+	// KeyColumns are the columns that uniquely identify rows, in the order they should be sorted by.
+	KeyColumns []string
+	// GetKey returns the values of the key columns for a row.
+	GetKey func(T) []any
+	// ConvertRow is the function used to scan rows.
+	ConvertRow ConvertRowFn[T]
+	// PageSize is the maximum number of rows fetched per query. Defaults to 1000.
+	PageSize int
+	// Descending makes the iteration go in descending order of the key columns.
+	Descending bool
+}
+
+func (kp *KeysetPagination[T]) buildQuery(argCount int, firstPage bool) string {
+	var query strings.Builder
+	query.WriteString("SELECT * FROM (")
+	query.WriteString(kp.Query)
+	query.WriteString(") AS keyset_page")
+	keyColumns := strings.Join(kp.KeyColumns, ", ")
+	if !firstPage {
+		placeholders := make([]string, len(kp.KeyColumns))
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", argCount+i+1)
+		}
+		operator := ">"
+		if kp.Descending {
+			operator = "<"
+		}
+		_, _ = fmt.Fprintf(&query, " WHERE (%s) %s (%s)", keyColumns, operator, strings.Join(placeholders, ", "))
+		argCount += len(kp.KeyColumns)
+	}
+	query.WriteString(" ORDER BY ")
+	if kp.Descending {
+		query.WriteString(strings.Join(kp.KeyColumns, " DESC, "))
+		query.WriteString(" DESC")
+	} else {
+		query.WriteString(keyColumns)
+	}
+	_, _ = fmt.Fprintf(&query, " LIMIT $%d", argCount+1)
+	return query.String()
+}
+
+// Iter returns a RowIter that fetches pages from the database as needed.
+// The args are passed to the base query.
+//
+// Each page is read fully before items are passed to the iterator function,
+// so the database connection isn't held while processing the items.
+func (kp *KeysetPagination[T]) Iter(ctx context.Context, db *Database, args ...any) RowIter[T] {
+	return &keysetIter[T]{kp: kp, ctx: ctx, db: db, args: args}
+}
+
+type keysetIter[T any] struct {
+	kp   *KeysetPagination[T]
+	ctx  context.Context
+	db   *Database
+	args []any
+	err  error
+}
+
+func (ki *keysetIter[T]) Iter(fn func(T) (bool, error)) error {
+	if ki.err != nil {
+		return ki.err
+	}
+	ki.err = ErrAlreadyIterated
+	pageSize := ki.kp.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	firstQuery := ki.kp.buildQuery(len(ki.args), true)
+	nextQuery := ki.kp.buildQuery(len(ki.args), false)
+	var lastKey []any
+	for {
+		query := nextQuery
+		args := append(ki.args[:len(ki.args):len(ki.args)], lastKey...)
+		if lastKey == nil {
+			query = firstQuery
+		}
+		args = append(args, pageSize)
+		page, err := ki.kp.ConvertRow.NewRowIter(ki.db.Query(ki.ctx, query, args...)).AsList()
+		if err != nil {
+			return err
+		}
+		for _, item := range page {
+			if cont, err := fn(item); err != nil {
+				return err
+			} else if !cont {
+				return nil
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		lastKey = ki.kp.GetKey(page[len(page)-1])
+		if len(lastKey) != len(ki.kp.KeyColumns) {
+			return fmt.Errorf("keyset pagination: GetKey returned %d values, expected %d", len(lastKey), len(ki.kp.KeyColumns))
+		}
+	}
+}
+
+func (ki *keysetIter[T]) AsList() (list []T, err error) {
+	err = ki.Iter(func(item T) (bool, error) {
+		list = append(list, item)
+		return true, nil
+	})
+	return
+}
@@ -0,0 +1,67 @@
+package dbutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type paginationTestRow struct {
+	Group int
+	TS    int
+	ID    int
+}
+
+func TestKeysetPagination(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, `
+		CREATE TABLE foo (grp INTEGER, ts INTEGER, id INTEGER PRIMARY KEY);
+		INSERT INTO foo (grp, ts, id) VALUES (1, 10, 1), (1, 10, 2), (1, 5, 3), (2, 1, 4), (1, 20, 5), (1, 10, 6), (1, 7, 7);
+	`)
+	require.NoError(t, err)
+
+	kp := &KeysetPagination[paginationTestRow]{
+		Query:      "SELECT grp, ts, id FROM foo WHERE grp=$1",
+		KeyColumns: []string{"ts", "id"},
+		GetKey: func(row paginationTestRow) []any {
+			return []any{row.TS, row.ID}
+		},
+		ConvertRow: func(row Scannable) (r paginationTestRow, err error) {
+			err = row.Scan(&r.Group, &r.TS, &r.ID)
+			return
+		},
+		PageSize: 2,
+	}
+	getIDs := func(rows []paginationTestRow) []int {
+		ids := make([]int, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+		return ids
+	}
+
+	rows, err := kp.Iter(ctx, db, 1).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 7, 1, 2, 6, 5}, getIDs(rows))
+
+	kp.Descending = true
+	rows, err = kp.Iter(ctx, db, 1).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 6, 2, 1, 7, 3}, getIDs(rows))
+
+	var partial []int
+	iter := kp.Iter(ctx, db, 1)
+	err = iter.Iter(func(row paginationTestRow) (bool, error) {
+		partial = append(partial, row.ID)
+		return len(partial) < 3, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 6, 2}, partial)
+	_, err = iter.AsList()
+	assert.ErrorIs(t, err, ErrAlreadyIterated)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package pgxcopy registers a [dbutil.CopyFunc] for pgx, which makes [dbutil.BulkLoader] use
+// `COPY ... FROM STDIN` on databases opened with the pgx driver.
+//
+// Import it for side effects:
+//
+//	import _ "go.mau.fi/util/dbutil/pgxcopy"
+package pgxcopy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"go.mau.fi/util/dbutil"
+)
+
+func init() {
+	dbutil.RegisterCopyFunc(stdlib.GetDefaultDriver(), Copy)
+}
+
+// Copy inserts rows into the given table using pgx's CopyFrom.
+//
+// database/sql doesn't expose the connection of a transaction, so this returns [dbutil.ErrCopyUnavailable]
+// if the context has a transaction, which makes the bulk loader fall back to INSERTs.
+func Copy(ctx context.Context, db *dbutil.Database, table string, columns []string, next func() []any) error {
+	if _, ok := db.Execable(ctx).(dbutil.Transaction); ok {
+		return dbutil.ErrCopyUnavailable
+	}
+	conn, err := db.RawDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for copy: %w", err)
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected connection type %T", driverConn)
+		}
+		// A single COPY statement is atomic, so no explicit transaction is needed.
+		_, err := pgxConn.Conn().CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromFunc(func() ([]any, error) {
+			return next(), nil
+		}))
+		if err != nil {
+			return fmt.Errorf("failed to copy rows: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,36 @@
+package pgxcopy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+)
+
+func TestSupportsCopy(t *testing.T) {
+	db, err := dbutil.NewWithDialect("postgres://localhost/meow", "pgx")
+	require.NoError(t, err)
+	defer db.Close()
+	assert.True(t, db.SupportsCopy())
+}
+
+func TestCopy_InTransaction(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := dbutil.NewWithDB(conn, "pgx")
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = db.DoTxn(context.Background(), nil, func(ctx context.Context) error {
+		return Copy(ctx, db, "foo", []string{"id"}, func() []any {
+			t.Fatal("rows shouldn't be read inside a transaction")
+			return nil
+		})
+	})
+	assert.ErrorIs(t, err, dbutil.ErrCopyUnavailable)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package pqcopy registers a [dbutil.CopyFunc] for lib/pq, which makes [dbutil.BulkLoader] use
+// `COPY ... FROM STDIN` on databases opened with the postgres driver.
+//
+// Import it for side effects:
+//
+//	import _ "go.mau.fi/util/dbutil/pqcopy"
+package pqcopy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"go.mau.fi/util/dbutil"
+)
+
+func init() {
+	dbutil.RegisterCopyFunc(&pq.Driver{}, Copy)
+}
+
+// Copy inserts rows into the given table using lib/pq's COPY support.
+//
+// The rows are copied inside the transaction in the context, or a new transaction if there isn't one,
+// as lib/pq only supports COPY inside transactions.
+func Copy(ctx context.Context, db *dbutil.Database, table string, columns []string, next func() []any) error {
+	return db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		txn, ok := db.Execable(ctx).(*dbutil.LoggingTxn)
+		if !ok {
+			return fmt.Errorf("unexpected transaction type %T", db.Execable(ctx))
+		}
+		stmt, err := txn.UnderlyingTx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+		if err != nil {
+			return fmt.Errorf("failed to start copy: %w", err)
+		}
+		defer stmt.Close()
+		for row := next(); row != nil; row = next() {
+			if _, err = stmt.ExecContext(ctx, row...); err != nil {
+				return fmt.Errorf("failed to copy row: %w", err)
+			}
+		}
+		// Executing without arguments flushes the buffered rows and finishes the copy
+		if _, err = stmt.ExecContext(ctx); err != nil {
+			return fmt.Errorf("failed to finish copy: %w", err)
+		}
+		return stmt.Close()
+	})
+}
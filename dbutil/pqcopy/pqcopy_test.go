@@ -0,0 +1,44 @@
+package pqcopy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+)
+
+func TestSupportsCopy(t *testing.T) {
+	db, err := dbutil.NewWithDialect("postgres://localhost/meow", "postgres")
+	require.NoError(t, err)
+	defer db.Close()
+	assert.True(t, db.SupportsCopy())
+}
+
+func TestCopy(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	db, err := dbutil.NewWithDB(conn, "postgres")
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(`COPY "foo" ("id", "value") FROM STDIN`)
+	prep.ExpectExec().WithArgs(1, "meow").WillReturnResult(sqlmock.NewResult(0, 0))
+	prep.ExpectExec().WithArgs(2, "hmm").WillReturnResult(sqlmock.NewResult(0, 0))
+	prep.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	rows := [][]any{{1, "meow"}, {2, "hmm"}}
+	err = Copy(context.Background(), db, "foo", []string{"id", "value"}, func() []any {
+		if len(rows) == 0 {
+			return nil
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
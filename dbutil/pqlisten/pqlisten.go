@@ -0,0 +1,87 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package pqlisten implements a [dbutil.ListenerBackend] for Postgres using lib/pq's LISTEN support.
+package pqlisten
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/util/dbutil"
+)
+
+const (
+	DefaultMinReconnectInterval = 1 * time.Second
+	DefaultMaxReconnectInterval = 1 * time.Minute
+	pingInterval                = 90 * time.Second
+)
+
+// Backend is a [dbutil.ListenerBackend] that uses a dedicated Postgres connection to LISTEN for notifications.
+// The underlying connection is reconnected automatically and channels are re-listened after reconnecting.
+type Backend struct {
+	listener *pq.Listener
+	log      zerolog.Logger
+}
+
+var _ dbutil.ListenerBackend = (*Backend)(nil)
+
+// New creates a new listener backend that connects to the given Postgres URI.
+func New(uri string, log zerolog.Logger) *Backend {
+	b := &Backend{log: log}
+	b.listener = pq.NewListener(uri, DefaultMinReconnectInterval, DefaultMaxReconnectInterval, b.onEvent)
+	return b
+}
+
+func (b *Backend) onEvent(ev pq.ListenerEventType, err error) {
+	switch ev {
+	case pq.ListenerEventConnected:
+		b.log.Debug().Msg("Notification listener connected")
+	case pq.ListenerEventDisconnected:
+		b.log.Warn().Err(err).Msg("Notification listener disconnected")
+	case pq.ListenerEventReconnected:
+		b.log.Info().Msg("Notification listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		b.log.Warn().Err(err).Msg("Notification listener failed to connect")
+	}
+}
+
+func (b *Backend) Listen(_ context.Context, channel string) error {
+	return b.listener.Listen(channel)
+}
+
+func (b *Backend) Unlisten(_ context.Context, channel string) error {
+	return b.listener.Unlisten(channel)
+}
+
+// Run delivers notifications until the context is canceled, after which the listener connection is closed.
+func (b *Backend) Run(ctx context.Context, deliver func(dbutil.Notification)) error {
+	defer b.listener.Close()
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notif := <-b.listener.Notify:
+			// A nil notification means the connection was re-established and notifications may have been lost.
+			if notif == nil {
+				deliver(dbutil.Notification{Reconnected: true})
+			} else {
+				deliver(dbutil.Notification{Channel: notif.Channel, Payload: notif.Extra})
+			}
+		case <-ticker.C:
+			go func() {
+				if err := b.listener.Ping(); err != nil {
+					b.log.Warn().Err(err).Msg("Failed to ping notification listener connection")
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsBuckets are the default histogram buckets (in seconds) used by [PrometheusMetrics].
+var DefaultMetricsBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, bucket := range buckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+type queryMetricKey struct {
+	method string
+	query  string
+}
+
+type namedPool struct {
+	name string
+	db   *sql.DB
+}
+
+// PrometheusMetrics is a [MetricsCollector] that exports metrics in the Prometheus text format.
+// It implements [http.Handler], so it can be mounted directly as a metrics endpoint.
+//
+// Example:
+//
+//	metrics := dbutil.NewPrometheusMetrics()
+//	metrics.AddDatabase("main", db)
+//	db.Metrics = metrics
+//	http.Handle("/metrics/db", metrics)
+type PrometheusMetrics struct {
+	// Buckets are the histogram buckets in seconds. They must be sorted and shouldn't be changed after use.
+	Buckets []float64
+
+	lock         sync.Mutex
+	queries      map[queryMetricKey]*histogram
+	errors       map[queryMetricKey]uint64
+	rows         map[string]uint64
+	transactions map[bool]*histogram
+	pools        []namedPool
+}
+
+var _ MetricsCollector = (*PrometheusMetrics)(nil)
+var _ http.Handler = (*PrometheusMetrics)(nil)
+
+// NewPrometheusMetrics creates a new PrometheusMetrics instance with the default buckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		Buckets:      DefaultMetricsBuckets,
+		queries:      make(map[queryMetricKey]*histogram),
+		errors:       make(map[queryMetricKey]uint64),
+		rows:         make(map[string]uint64),
+		transactions: make(map[bool]*histogram),
+	}
+}
+
+// AddPool adds a connection pool whose [sql.DBStats] should be exported with the given name as a label.
+func (pm *PrometheusMetrics) AddPool(name string, db *sql.DB) {
+	pm.lock.Lock()
+	pm.pools = append(pm.pools, namedPool{name: name, db: db})
+	pm.lock.Unlock()
+}
+
+// AddDatabase adds the connection pools of the given database. The read-only pool (if any)
+// is added with a "_ro" suffix in the name.
+func (pm *PrometheusMetrics) AddDatabase(name string, db *Database) {
+	pm.AddPool(name, db.RawDB)
+	if db.ReadOnlyDB != nil {
+		pm.AddPool(name+"_ro", db.ReadOnlyDB)
+	}
+}
+
+func (pm *PrometheusMetrics) ObserveQuery(_ context.Context, method, query string, nrows int, duration time.Duration, err error) {
+	key := queryMetricKey{method: method, query: query}
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	hist, ok := pm.queries[key]
+	if !ok {
+		hist = &histogram{}
+		pm.queries[key] = hist
+	}
+	hist.observe(pm.Buckets, duration.Seconds())
+	if err != nil {
+		pm.errors[key]++
+	}
+	if nrows >= 0 {
+		pm.rows[query] += uint64(nrows)
+	}
+}
+
+func (pm *PrometheusMetrics) ObserveTransaction(_ context.Context, duration time.Duration, committed bool) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	hist, ok := pm.transactions[committed]
+	if !ok {
+		hist = &histogram{}
+		pm.transactions[committed] = hist
+	}
+	hist.observe(pm.Buckets, duration.Seconds())
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels ...string) string {
+	var buf strings.Builder
+	buf.WriteByte('{')
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(labels[i])
+		buf.WriteString(`="`)
+		buf.WriteString(labelValueEscaper.Replace(labels[i+1]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func writeHistogram(w io.Writer, name string, buckets []float64, hist *histogram, labels ...string) {
+	for i, bucket := range buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(append(labels, "le", formatFloat(bucket))...), hist.counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(append(labels, "le", "+Inf")...), hist.count)
+	_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels...), formatFloat(hist.sum))
+	_, _ = fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels...), hist.count)
+}
+
+func sortedKeys[K comparable, V any](m map[K]V, cmp func(a, b K) int) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+func compareQueryMetricKeys(a, b queryMetricKey) int {
+	if a.query != b.query {
+		return strings.Compare(a.query, b.query)
+	}
+	return strings.Compare(a.method, b.method)
+}
+
+// WriteTo writes all metrics to the given writer in the Prometheus text format.
+func (pm *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	pm.lock.Lock()
+	_, _ = fmt.Fprintln(cw, "# HELP dbutil_query_duration_seconds Duration of database calls.")
+	_, _ = fmt.Fprintln(cw, "# TYPE dbutil_query_duration_seconds histogram")
+	for _, key := range sortedKeys(pm.queries, compareQueryMetricKeys) {
+		writeHistogram(cw, "dbutil_query_duration_seconds", pm.Buckets, pm.queries[key], "method", key.method, "query", key.query)
+	}
+	_, _ = fmt.Fprintln(cw, "# HELP dbutil_query_errors_total Number of database calls that returned an error.")
+	_, _ = fmt.Fprintln(cw, "# TYPE dbutil_query_errors_total counter")
+	for _, key := range sortedKeys(pm.errors, compareQueryMetricKeys) {
+		_, _ = fmt.Fprintf(cw, "dbutil_query_errors_total%s %d\n", formatLabels("method", key.method, "query", key.query), pm.errors[key])
+	}
+	_, _ = fmt.Fprintln(cw, "# HELP dbutil_query_rows_total Number of rows read from query results.")
+	_, _ = fmt.Fprintln(cw, "# TYPE dbutil_query_rows_total counter")
+	for _, query := range sortedKeys(pm.rows, strings.Compare) {
+		_, _ = fmt.Fprintf(cw, "dbutil_query_rows_total%s %d\n", formatLabels("query", query), pm.rows[query])
+	}
+	_, _ = fmt.Fprintln(cw, "# HELP dbutil_transaction_duration_seconds Duration of transactions.")
+	_, _ = fmt.Fprintln(cw, "# TYPE dbutil_transaction_duration_seconds histogram")
+	for _, committed := range []bool{true, false} {
+		if hist, ok := pm.transactions[committed]; ok {
+			result := "rollback"
+			if committed {
+				result = "commit"
+			}
+			writeHistogram(cw, "dbutil_transaction_duration_seconds", pm.Buckets, hist, "result", result)
+		}
+	}
+	pools := slices.Clone(pm.pools)
+	pm.lock.Unlock()
+
+	poolGauges := []struct {
+		name, help, typ string
+		get             func(sql.DBStats) string
+	}{
+		{"dbutil_pool_max_open_connections", "Maximum number of open connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.MaxOpenConnections) }},
+		{"dbutil_pool_open_connections", "Number of open connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.OpenConnections) }},
+		{"dbutil_pool_in_use_connections", "Number of connections currently in use.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.InUse) }},
+		{"dbutil_pool_idle_connections", "Number of idle connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.Idle) }},
+		{"dbutil_pool_wait_count_total", "Total number of times a connection had to be waited for.", "counter", func(s sql.DBStats) string { return strconv.FormatInt(s.WaitCount, 10) }},
+		{"dbutil_pool_wait_duration_seconds_total", "Total time spent waiting for connections.", "counter", func(s sql.DBStats) string { return formatFloat(s.WaitDuration.Seconds()) }},
+	}
+	stats := make([]sql.DBStats, len(pools))
+	for i, pool := range pools {
+		stats[i] = pool.db.Stats()
+	}
+	for _, gauge := range poolGauges {
+		_, _ = fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", gauge.name, gauge.help, gauge.name, gauge.typ)
+		for i, pool := range pools {
+			_, _ = fmt.Fprintf(cw, "%s%s %s\n", gauge.name, formatLabels("pool", pool.name), gauge.get(stats[i]))
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP writes all metrics to the response in the Prometheus text format.
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = pm.WriteTo(w)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package puresqlite registers SQLite drivers that use the pure-Go modernc.org/sqlite implementation,
+// which allows using SQLite in builds without cgo.
+//
+// The drivers are equivalent to the ones in go.mau.fi/util/dbutil/litestream, but with a -purego suffix
+// in the name (e.g. sqlite3-fk-wal-purego). In builds without cgo, the litestream package also registers
+// these drivers under the standard names, so configs don't need to be changed for cgo-less builds.
+package puresqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+// NameSuffix is appended to the standard driver names when registering the pure-Go drivers.
+const NameSuffix = "-purego"
+
+var (
+	// Litestream is the equivalent of the litestream driver, which keeps the WAL file persistent
+	// and disables automatic checkpoints so that litestream can manage them.
+	Litestream = newDriver(true,
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA wal_autocheckpoint = 0",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+	)
+	// FKWAL is the equivalent of the sqlite3-fk-wal driver.
+	FKWAL = newDriver(false,
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+	)
+	// FKWALFullSync is the equivalent of the sqlite3-fk-wal-fullsync driver.
+	FKWALFullSync = newDriver(false,
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = FULL",
+		"PRAGMA busy_timeout = 5000",
+	)
+)
+
+// Drivers maps the standard driver names to the pure-Go driver implementations.
+var Drivers = map[string]driver.Driver{
+	"litestream":              Litestream,
+	"sqlite3-fk-wal":          FKWAL,
+	"sqlite3-fk-wal-fullsync": FKWALFullSync,
+}
+
+func newDriver(persistWAL bool, pragmas ...string) *sqlite.Driver {
+	drv := &sqlite.Driver{}
+	drv.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, _ string) error {
+		if persistWAL {
+			fileControl, ok := conn.(sqlite.FileControl)
+			if !ok {
+				return fmt.Errorf("connection %T doesn't support file control", conn)
+			} else if _, err := fileControl.FileControlPersistWAL("main", 1); err != nil {
+				return fmt.Errorf("failed to enable persistent WAL: %w", err)
+			}
+		}
+		for _, pragma := range pragmas {
+			if _, err := conn.ExecContext(context.Background(), pragma, nil); err != nil {
+				return fmt.Errorf("failed to execute %q: %w", pragma, err)
+			}
+		}
+		return nil
+	})
+	return drv
+}
+
+func init() {
+	for name, drv := range Drivers {
+		sql.Register(name+NameSuffix, drv)
+	}
+}
@@ -0,0 +1,55 @@
+package puresqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/puresqlite"
+)
+
+func TestDrivers(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name        string
+		synchronous int
+		checkpoint  int
+	}{
+		{"litestream-purego", 1, 0},
+		{"sqlite3-fk-wal-purego", 1, 1000},
+		{"sqlite3-fk-wal-fullsync-purego", 2, 1000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := dbutil.NewWithDialect(filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate", test.name)
+			require.NoError(t, err)
+			defer db.Close()
+			assert.Equal(t, dbutil.SQLite, db.Dialect)
+			var foreignKeys, synchronous, busyTimeout, checkpoint int
+			var journalMode string
+			require.NoError(t, db.QueryRow(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys))
+			require.NoError(t, db.QueryRow(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+			require.NoError(t, db.QueryRow(ctx, "PRAGMA synchronous").Scan(&synchronous))
+			require.NoError(t, db.QueryRow(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout))
+			require.NoError(t, db.QueryRow(ctx, "PRAGMA wal_autocheckpoint").Scan(&checkpoint))
+			assert.Equal(t, 1, foreignKeys)
+			assert.Equal(t, "wal", journalMode)
+			assert.Equal(t, test.synchronous, synchronous)
+			assert.Equal(t, 5000, busyTimeout)
+			assert.Equal(t, test.checkpoint, checkpoint)
+
+			_, err = db.Exec(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY, value TEXT NOT NULL)")
+			require.NoError(t, err)
+			require.NoError(t, db.DoTxn(ctx, nil, func(ctx context.Context) error {
+				_, err := db.Exec(ctx, "INSERT INTO foo (id, value) VALUES ($1, $2)", 1, "meow")
+				return err
+			}))
+			var value string
+			require.NoError(t, db.QueryRow(ctx, "SELECT value FROM foo WHERE id=$1", 1).Scan(&value))
+			assert.Equal(t, "meow", value)
+		})
+	}
+}
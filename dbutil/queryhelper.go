@@ -26,8 +26,9 @@ type DataStruct[T any] interface {
 // After implementing the Scan and Init methods in a data struct, the query
 // helper allows writing query functions in a single line.
 type QueryHelper[T DataStruct[T]] struct {
-	db      *Database
-	newFunc func(qh *QueryHelper[T]) T
+	db        *Database
+	newFunc   func(qh *QueryHelper[T]) T
+	structTag string
 }
 
 // MakeQueryHelperSimple is a form of MakeQueryHelper where the constructor function does not
@@ -9,6 +9,7 @@ package dbutil
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // MakeSimpleReflectScanner creates a ConvertRowFn that uses reflection to scan rows into the given type.
@@ -28,15 +29,26 @@ func MakeSimpleReflectScanner[T any]() ConvertRowFn[*T] {
 	}
 }
 
+// getColumnName returns the column name of the given struct field based on the given struct tag,
+// as well as any comma-separated options after the name. Fields without a name in the tag use the
+// field name as the column name, while fields tagged with "-" are skipped (ok is false).
+func getColumnName(field reflect.StructField, structTag string) (name, opts string, ok bool) {
+	name, opts, _ = strings.Cut(field.Tag.Get(structTag), ",")
+	if name == "-" {
+		return "", "", false
+	} else if name == "" {
+		name = field.Name
+	}
+	return name, opts, true
+}
+
 func getFieldMap[T any](structTag string) map[string][]int {
 	fields := reflect.VisibleFields(reflect.TypeFor[T]())
 	m := make(map[string][]int, len(fields))
 	for _, field := range fields {
-		sqlName := field.Tag.Get(structTag)
-		if sqlName == "" {
-			sqlName = field.Name
+		if sqlName, _, ok := getColumnName(field, structTag); ok {
+			m[sqlName] = field.Index
 		}
-		m[sqlName] = field.Index
 	}
 	return m
 }
@@ -0,0 +1,45 @@
+package dbutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reflectScanTestRow struct {
+	ID       int64  `column:"id,generated"`
+	Name     string `column:"name"`
+	Ignored  string `column:"-"`
+	Untagged string
+}
+
+var reflectScanTestOpts = ReflectScanOptions{StructTag: defaultReflectStructTag}
+
+func TestMakeReflectScanner_TagOptions(t *testing.T) {
+	scanner, err := MakeReflectScanner[reflectScanTestRow]([]string{"id", "name", "Untagged"}, reflectScanTestOpts)
+	require.NoError(t, err)
+	row, err := scanner(scannableFunc(func(dest ...any) error {
+		*dest[0].(*int64) = 5
+		*dest[1].(*string) = "meow"
+		*dest[2].(*string) = "hmm"
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, &reflectScanTestRow{ID: 5, Name: "meow", Untagged: "hmm"}, row)
+
+	// Options after the comma aren't part of the column name
+	_, err = MakeReflectScanner[reflectScanTestRow]([]string{"id,generated"}, reflectScanTestOpts)
+	assert.ErrorContains(t, err, `column "id,generated" does not match any struct field`)
+	// Fields tagged with "-" are never scanned
+	_, err = MakeReflectScanner[reflectScanTestRow]([]string{"-"}, reflectScanTestOpts)
+	assert.ErrorContains(t, err, `column "-" does not match any struct field`)
+	_, err = MakeReflectScanner[reflectScanTestRow]([]string{"Ignored"}, reflectScanTestOpts)
+	assert.ErrorContains(t, err, `column "Ignored" does not match any struct field`)
+}
+
+type scannableFunc func(dest ...any) error
+
+func (fn scannableFunc) Scan(dest ...any) error {
+	return fn(dest...)
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoWritableColumns = errors.New("reflectwrite: struct has no writable columns")
+	ErrNilWriteStruct    = errors.New("reflectwrite: can't write nil struct")
+)
+
+type writeColumn struct {
+	name  string
+	index []int
+}
+
+type writeQueryKind int
+
+const (
+	writeQueryInsert writeQueryKind = iota
+	writeQueryUpsert
+	writeQueryUpdate
+)
+
+type writeQueryKey struct {
+	typ       reflect.Type
+	structTag string
+	kind      writeQueryKind
+	table     string
+	keyCols   string
+}
+
+type writeQuery struct {
+	query  string
+	fields [][]int
+}
+
+var writeQueryCache sync.Map // map[writeQueryKey]*writeQuery
+
+// getWriteColumns returns the columns of the given struct type that should be written to the database.
+//
+// Columns are mapped the same way as in reflection-based scanning (see [ReflectScanOptions]),
+// but only exported fields are included, and embedded structs are skipped as their fields are
+// included individually. Fields can be excluded from writes while still being
+// scanned by adding the `generated` option (e.g. `column:"id,generated"`).
+func getWriteColumns(typ reflect.Type, structTag string) []writeColumn {
+	var columns []writeColumn
+	for _, field := range reflect.VisibleFields(typ) {
+		if !field.IsExported() || (field.Anonymous && indirectType(field.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		name, opts, ok := getColumnName(field, structTag)
+		if !ok || slices.Contains(strings.Split(opts, ","), "generated") {
+			continue
+		}
+		columns = append(columns, writeColumn{name: name, index: field.Index})
+	}
+	return columns
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Pointer {
+		return typ.Elem()
+	}
+	return typ
+}
+
+func buildWriteQuery(typ reflect.Type, structTag string, kind writeQueryKind, table string, keyCols []string) (*writeQuery, error) {
+	columns := getWriteColumns(typ, structTag)
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoWritableColumns, typ)
+	}
+	columnNames := make([]string, len(columns))
+	for i, col := range columns {
+		columnNames[i] = col.name
+	}
+	for _, key := range keyCols {
+		if !slices.Contains(columnNames, key) {
+			return nil, fmt.Errorf("reflectwrite: key column %q does not match any struct field", key)
+		}
+	}
+	quotedTable := quoteIdentifiers([]string{table})[0]
+	wq := &writeQuery{}
+	var query strings.Builder
+	switch kind {
+	case writeQueryInsert, writeQueryUpsert:
+		placeholders := make([]string, len(columns))
+		for i, col := range columns {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			wq.fields = append(wq.fields, col.index)
+		}
+		_, _ = fmt.Fprintf(
+			&query, "INSERT INTO %s (%s) VALUES (%s)",
+			quotedTable, strings.Join(quoteIdentifiers(columnNames), ", "), strings.Join(placeholders, ", "),
+		)
+		if kind == writeQueryUpsert {
+			var updates []string
+			quotedKeys := quoteIdentifiers(keyCols)
+			for _, col := range quoteIdentifiers(columnNames) {
+				if !slices.Contains(quotedKeys, col) {
+					updates = append(updates, fmt.Sprintf("%s=excluded.%s", col, col))
+				}
+			}
+			_, _ = fmt.Fprintf(&query, " ON CONFLICT (%s) DO ", strings.Join(quotedKeys, ", "))
+			if len(updates) == 0 {
+				query.WriteString("NOTHING")
+			} else {
+				query.WriteString("UPDATE SET ")
+				query.WriteString(strings.Join(updates, ", "))
+			}
+		}
+	case writeQueryUpdate:
+		var updates, conditions []string
+		var keyFields [][]int
+		for _, col := range columns {
+			if slices.Contains(keyCols, col.name) {
+				continue
+			}
+			wq.fields = append(wq.fields, col.index)
+			updates = append(updates, fmt.Sprintf("%s=$%d", quoteIdentifiers([]string{col.name})[0], len(wq.fields)))
+		}
+		if len(updates) == 0 {
+			return nil, fmt.Errorf("%w: all columns are key columns", ErrNoWritableColumns)
+		}
+		for _, key := range keyCols {
+			idx := slices.Index(columnNames, key)
+			keyFields = append(keyFields, columns[idx].index)
+			conditions = append(conditions, fmt.Sprintf("%s=$%d", quoteIdentifiers([]string{key})[0], len(wq.fields)+len(keyFields)))
+		}
+		wq.fields = append(wq.fields, keyFields...)
+		_, _ = fmt.Fprintf(&query, "UPDATE %s SET %s WHERE %s", quotedTable, strings.Join(updates, ", "), strings.Join(conditions, " AND "))
+	}
+	wq.query = query.String()
+	return wq, nil
+}
+
+func getWriteQuery(typ reflect.Type, structTag string, kind writeQueryKind, table string, keyCols []string) (*writeQuery, error) {
+	key := writeQueryKey{typ: typ, structTag: structTag, kind: kind, table: table, keyCols: strings.Join(keyCols, ",")}
+	if cached, ok := writeQueryCache.Load(key); ok {
+		return cached.(*writeQuery), nil
+	}
+	wq, err := buildWriteQuery(typ, structTag, kind, table, keyCols)
+	if err != nil {
+		return nil, err
+	}
+	writeQueryCache.Store(key, wq)
+	return wq, nil
+}
+
+func (qh *QueryHelper[T]) execWrite(ctx context.Context, kind writeQueryKind, table string, obj T, keyCols []string) error {
+	val := reflect.ValueOf(obj)
+	if val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return ErrNilWriteStruct
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() {
+		return ErrNilWriteStruct
+	} else if val.Kind() != reflect.Struct {
+		return fmt.Errorf("reflectwrite: expected struct or pointer to struct, got %s", val.Type())
+	}
+	structTag := qh.structTag
+	if structTag == "" {
+		structTag = defaultReflectStructTag
+	}
+	wq, err := getWriteQuery(val.Type(), structTag, kind, table, keyCols)
+	if err != nil {
+		return err
+	}
+	args := make([]any, len(wq.fields))
+	for i, idx := range wq.fields {
+		field, err := val.FieldByIndexErr(idx)
+		if err != nil {
+			return fmt.Errorf("reflectwrite: %w", err)
+		}
+		args[i] = field.Interface()
+	}
+	return qh.Exec(ctx, wq.query, args...)
+}
+
+// Insert inserts the given struct into the given table using reflection.
+//
+// All exported fields are inserted using the same column names as [NewReflectRowIter], i.e. the
+// name in the `column` struct tag (or the tag set with [QueryHelper.WithStructTag]), falling back
+// to the field name. Fields tagged with "-" are skipped, as are ones with the `generated` option
+// (e.g. `column:"rowid,generated"`), which is meant for columns filled by the database.
+// The generated query is cached per type and table.
+func (qh *QueryHelper[T]) Insert(ctx context.Context, table string, obj T) error {
+	return qh.execWrite(ctx, writeQueryInsert, table, obj, nil)
+}
+
+// Upsert inserts the given struct into the given table, or updates all other columns of the existing
+// row if it conflicts on the given columns. If all columns are conflict columns, conflicts are ignored.
+// See [QueryHelper.Insert] for how columns are chosen.
+func (qh *QueryHelper[T]) Upsert(ctx context.Context, table string, obj T, conflictCols ...string) error {
+	if len(conflictCols) == 0 {
+		return fmt.Errorf("reflectwrite: no conflict columns specified for upsert")
+	}
+	return qh.execWrite(ctx, writeQueryUpsert, table, obj, conflictCols)
+}
+
+// Update updates all columns of the row identified by the given key columns to match the given struct.
+// See [QueryHelper.Insert] for how columns are chosen.
+func (qh *QueryHelper[T]) Update(ctx context.Context, table string, obj T, keyCols ...string) error {
+	if len(keyCols) == 0 {
+		return fmt.Errorf("reflectwrite: no key columns specified for update")
+	}
+	return qh.execWrite(ctx, writeQueryUpdate, table, obj, keyCols)
+}
+
+// WithStructTag returns a copy of the query helper that uses the given struct tag instead of `column`
+// for the column names in [QueryHelper.Insert], [QueryHelper.Upsert] and [QueryHelper.Update],
+// like [ReflectScanOptions.StructTag] does for scanning.
+func (qh *QueryHelper[T]) WithStructTag(tag string) *QueryHelper[T] {
+	clone := *qh
+	clone.structTag = tag
+	return &clone
+}
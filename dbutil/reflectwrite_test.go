@@ -0,0 +1,105 @@
+package dbutil
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reflectWriteTestRow struct {
+	RowID   int64  `column:"rowid,generated"`
+	Key     string `column:"key"`
+	Value   string `column:"value"`
+	Counter int    `column:"counter"`
+	Ignored string `column:"-"`
+}
+
+func (r *reflectWriteTestRow) Scan(row Scannable) (*reflectWriteTestRow, error) {
+	return ValueOrErr(r, row.Scan(&r.RowID, &r.Key, &r.Value, &r.Counter))
+}
+
+func TestBuildWriteQuery(t *testing.T) {
+	typ := reflect.TypeFor[reflectWriteTestRow]()
+	wq, err := buildWriteQuery(typ, defaultReflectStructTag, writeQueryInsert, "foo", nil)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "foo" ("key", "value", "counter") VALUES ($1, $2, $3)`, wq.query)
+	wq, err = buildWriteQuery(typ, defaultReflectStructTag, writeQueryUpsert, "foo", []string{"key"})
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "foo" ("key", "value", "counter") VALUES ($1, $2, $3) ON CONFLICT ("key") DO UPDATE SET "value"=excluded."value", "counter"=excluded."counter"`, wq.query)
+	wq, err = buildWriteQuery(typ, defaultReflectStructTag, writeQueryUpsert, "foo", []string{"key", "value", "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "foo" ("key", "value", "counter") VALUES ($1, $2, $3) ON CONFLICT ("key", "value", "counter") DO NOTHING`, wq.query)
+	wq, err = buildWriteQuery(typ, defaultReflectStructTag, writeQueryUpdate, "foo", []string{"key"})
+	require.NoError(t, err)
+	assert.Equal(t, `UPDATE "foo" SET "value"=$1, "counter"=$2 WHERE "key"=$3`, wq.query)
+	assert.Equal(t, [][]int{{2}, {3}, {1}}, wq.fields)
+	_, err = buildWriteQuery(typ, defaultReflectStructTag, writeQueryUpdate, "foo", []string{"meow"})
+	assert.Error(t, err)
+	_, err = buildWriteQuery(reflect.TypeFor[struct {
+		A int `column:"-"`
+		b int
+	}](), defaultReflectStructTag, writeQueryInsert, "foo", nil)
+	assert.ErrorIs(t, err, ErrNoWritableColumns)
+	wq, err = buildWriteQuery(reflect.TypeFor[struct {
+		A int
+		B int `db:"bee"`
+		C int `column:"-"`
+	}](), "db", writeQueryInsert, "foo", nil)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "foo" ("A", "bee", "C") VALUES ($1, $2, $3)`, wq.query)
+}
+
+func TestQueryHelper_Write(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (key TEXT PRIMARY KEY, value TEXT NOT NULL, counter INTEGER NOT NULL)")
+	require.NoError(t, err)
+	qh := MakeQueryHelper[*reflectWriteTestRow](db, nil)
+	const getQuery = "SELECT rowid, key, value, counter FROM foo WHERE key=$1"
+
+	require.NoError(t, qh.Insert(ctx, "foo", &reflectWriteTestRow{Key: "a", Value: "meow", Counter: 1}))
+	require.Error(t, qh.Insert(ctx, "foo", &reflectWriteTestRow{Key: "a", Value: "meow", Counter: 1}))
+	require.NoError(t, qh.Upsert(ctx, "foo", &reflectWriteTestRow{Key: "a", Value: "hmm", Counter: 2}, "key"))
+	row, err := qh.QueryOne(ctx, getQuery, "a")
+	require.NoError(t, err)
+	assert.Equal(t, &reflectWriteTestRow{RowID: 1, Key: "a", Value: "hmm", Counter: 2}, row)
+
+	row.Counter = 3
+	require.NoError(t, qh.Update(ctx, "foo", row, "key"))
+	row, err = qh.QueryOne(ctx, getQuery, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, row.Counter)
+}
+
+type reflectWriteTagTestRow struct {
+	Key   string `db:"key"`
+	Value string `db:"value"`
+}
+
+func (r *reflectWriteTagTestRow) Scan(row Scannable) (*reflectWriteTagTestRow, error) {
+	return ValueOrErr(r, row.Scan(&r.Key, &r.Value))
+}
+
+func TestQueryHelper_WriteStructTag(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (key TEXT PRIMARY KEY, value TEXT NOT NULL)")
+	require.NoError(t, err)
+	qh := MakeQueryHelper[*reflectWriteTagTestRow](db, nil).WithStructTag("db")
+
+	require.NoError(t, qh.Insert(ctx, "foo", &reflectWriteTagTestRow{Key: "a", Value: "meow"}))
+	rows, err := db.Query(ctx, "SELECT key, value FROM foo")
+	items, err := NewReflectRowIterWithOptions[reflectWriteTagTestRow](rows, err, ReflectScanOptions{StructTag: "db"}).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []*reflectWriteTagTestRow{{Key: "a", Value: "meow"}}, items)
+
+	assert.ErrorIs(t, qh.Insert(ctx, "foo", nil), ErrNilWriteStruct)
+	assert.ErrorIs(t, qh.Update(ctx, "foo", (*reflectWriteTagTestRow)(nil), "key"), ErrNilWriteStruct)
+}
@@ -0,0 +1,3 @@
+-- v5 -> v4: Revert sample backwards-compatible upgrade
+
+DELETE FROM foo WHERE key='meow 2';
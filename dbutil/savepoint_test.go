@@ -0,0 +1,54 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_DoTxn_Nested(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (value INTEGER NOT NULL)")
+	require.NoError(t, err)
+	insert := func(ctx context.Context, value int) {
+		_, err := db.Exec(ctx, "INSERT INTO foo (value) VALUES ($1)", value)
+		require.NoError(t, err)
+	}
+	errInner := errors.New("inner failure")
+	nested := &TxnOptions{Nested: true}
+
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		insert(ctx, 1)
+		err := db.DoTxn(ctx, nested, func(ctx context.Context) error {
+			insert(ctx, 2)
+			return db.DoTxn(ctx, nested, func(ctx context.Context) error {
+				insert(ctx, 3)
+				return errInner
+			})
+		})
+		assert.ErrorIs(t, err, errInner)
+		err = db.DoTxn(ctx, nested, func(ctx context.Context) error {
+			insert(ctx, 4)
+			return db.DoTxn(ctx, nested, func(ctx context.Context) error {
+				insert(ctx, 5)
+				return errInner
+			})
+		})
+		assert.ErrorIs(t, err, errInner)
+		return db.DoTxn(ctx, nested, func(ctx context.Context) error {
+			insert(ctx, 6)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	values, err := ConvertRowFn[int](ScanSingleColumn[int]).NewRowIter(db.Query(ctx, "SELECT value FROM foo ORDER BY value")).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 6}, values)
+}
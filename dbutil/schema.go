@@ -0,0 +1,573 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Schema is a normalized description of the tables in a database.
+//
+// Column types are normalized into generic type families (e.g. all integer types become "integer")
+// and constraint names are not included, so that the schemas of Postgres and SQLite databases
+// created by the same upgrades can be compared directly.
+type Schema struct {
+	Tables []*SchemaTable
+}
+
+// SchemaTable describes a single table in a [Schema].
+type SchemaTable struct {
+	Name        string
+	Columns     []SchemaColumn
+	Constraints []SchemaConstraint
+	// Indexes contains explicitly created indexes, i.e. not ones that exist to back constraints.
+	Indexes []SchemaIndex
+}
+
+// SchemaColumn describes a single column in a [SchemaTable].
+type SchemaColumn struct {
+	Name    string
+	Type    string
+	NotNull bool
+}
+
+type SchemaConstraintType string
+
+const (
+	SchemaConstraintPrimaryKey SchemaConstraintType = "primary key"
+	SchemaConstraintUnique     SchemaConstraintType = "unique"
+	SchemaConstraintForeignKey SchemaConstraintType = "foreign key"
+)
+
+// SchemaConstraint describes a primary key, unique or foreign key constraint in a [SchemaTable].
+type SchemaConstraint struct {
+	Type    SchemaConstraintType
+	Columns []string
+
+	// The fields below are only set for foreign keys.
+	RefTable   string
+	RefColumns []string
+	OnUpdate   string
+	OnDelete   string
+}
+
+// SchemaIndex describes a single index in a [SchemaTable].
+type SchemaIndex struct {
+	Name    string
+	Unique  bool
+	Columns []string
+}
+
+func (sc SchemaColumn) String() string {
+	if sc.NotNull {
+		return fmt.Sprintf("column %s %s not null", sc.Name, sc.Type)
+	}
+	return fmt.Sprintf("column %s %s", sc.Name, sc.Type)
+}
+
+func (sc SchemaConstraint) String() string {
+	if sc.Type != SchemaConstraintForeignKey {
+		return fmt.Sprintf("%s (%s)", sc.Type, strings.Join(sc.Columns, ", "))
+	}
+	return fmt.Sprintf(
+		"%s (%s) references %s (%s) on update %s on delete %s",
+		sc.Type, strings.Join(sc.Columns, ", "), sc.RefTable, strings.Join(sc.RefColumns, ", "), sc.OnUpdate, sc.OnDelete,
+	)
+}
+
+func (si SchemaIndex) String() string {
+	if si.Unique {
+		return fmt.Sprintf("unique index %s (%s)", si.Name, strings.Join(si.Columns, ", "))
+	}
+	return fmt.Sprintf("index %s (%s)", si.Name, strings.Join(si.Columns, ", "))
+}
+
+func (st *SchemaTable) lines() []string {
+	lines := make([]string, 0, len(st.Columns)+len(st.Constraints)+len(st.Indexes))
+	for _, col := range st.Columns {
+		lines = append(lines, col.String())
+	}
+	for _, constraint := range st.Constraints {
+		lines = append(lines, constraint.String())
+	}
+	for _, index := range st.Indexes {
+		lines = append(lines, index.String())
+	}
+	return lines
+}
+
+// Table returns the table with the given name, or nil if it doesn't exist.
+func (s *Schema) Table(name string) *SchemaTable {
+	for _, table := range s.Tables {
+		if table.Name == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// String returns a stable line-based representation of the schema, which is suitable for snapshots.
+func (s *Schema) String() string {
+	var buf strings.Builder
+	for i, table := range s.Tables {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("table ")
+		buf.WriteString(table.Name)
+		buf.WriteByte('\n')
+		for _, line := range table.lines() {
+			buf.WriteByte('\t')
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+func (s *Schema) qualifiedLines() []string {
+	var lines []string
+	for _, table := range s.Tables {
+		lines = append(lines, "table "+table.Name)
+		for _, line := range table.lines() {
+			lines = append(lines, table.Name+": "+line)
+		}
+	}
+	return lines
+}
+
+// qualifySnapshotLines converts the output of [Schema.String] into the format of [Schema.qualifiedLines],
+// so that the diff shows which table each line belongs to.
+func qualifySnapshotLines(snapshot string) (lines []string) {
+	var table string
+	for _, line := range strings.Split(snapshot, "\n") {
+		if name, ok := strings.CutPrefix(line, "table "); ok {
+			table = name
+			lines = append(lines, line)
+		} else if content, ok := strings.CutPrefix(line, "\t"); ok {
+			lines = append(lines, table+": "+content)
+		} else if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+// Diff compares the schema to another schema. Each returned line is prefixed with
+// "-" if it only exists in this schema and "+" if it only exists in the other schema.
+func (s *Schema) Diff(other *Schema) []string {
+	return diffLines(s.qualifiedLines(), other.qualifiedLines())
+}
+
+func diffLines(old, new []string) (diff []string) {
+	for _, line := range old {
+		if !slices.Contains(new, line) {
+			diff = append(diff, "-"+line)
+		}
+	}
+	for _, line := range new {
+		if !slices.Contains(old, line) {
+			diff = append(diff, "+"+line)
+		}
+	}
+	return
+}
+
+var typeParamsRegex = regexp.MustCompile(`\s*\(.*\)`)
+
+// integerTypes contains the names of integer types in Postgres and SQLite. Other types containing "int"
+// (like the Postgres point, interval and int4range types) must not be treated as integers.
+var integerTypes = map[string]bool{
+	"int": true, "integer": true, "tinyint": true, "smallint": true, "mediumint": true, "bigint": true,
+	"int2": true, "int4": true, "int8": true, "unsigned big int": true,
+	"serial": true, "smallserial": true, "bigserial": true, "serial2": true, "serial4": true, "serial8": true,
+}
+
+func normalizeColumnType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typeParamsRegex.ReplaceAllLiteralString(typ, "")))
+	switch {
+	case typ == "":
+		return "any"
+	case integerTypes[typ]:
+		return "integer"
+	case strings.Contains(typ, "char"), strings.Contains(typ, "clob"), typ == "text", typ == "citext":
+		return "text"
+	case typ == "blob", typ == "bytea":
+		return "blob"
+	case typ == "bool", typ == "boolean":
+		return "boolean"
+	case typ == "json", typ == "jsonb":
+		return "json"
+	case typ == "real", strings.HasPrefix(typ, "float"), strings.HasPrefix(typ, "double"):
+		return "real"
+	case typ == "numeric", typ == "decimal":
+		return "numeric"
+	case strings.HasPrefix(typ, "timestamp"), typ == "datetime":
+		return "timestamp"
+	default:
+		return typ
+	}
+}
+
+var postgresForeignKeyActions = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+const (
+	listTablesPostgres = `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema=current_schema() AND table_type='BASE TABLE'
+	`
+	listColumnsPostgres = `
+		SELECT column_name, data_type, is_nullable='NO' FROM information_schema.columns
+		WHERE table_schema=current_schema() AND table_name=$1
+		ORDER BY ordinal_position
+	`
+	listConstraintsPostgres = `
+		SELECT
+			c.contype::text,
+			array_to_string(ARRAY(
+				SELECT a.attname FROM unnest(c.conkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid=c.conrelid AND a.attnum=k.attnum
+				ORDER BY k.ord
+			), ','),
+			COALESCE(ft.relname, ''),
+			array_to_string(ARRAY(
+				SELECT a.attname FROM unnest(c.confkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid=c.confrelid AND a.attnum=k.attnum
+				ORDER BY k.ord
+			), ','),
+			c.confupdtype::text,
+			c.confdeltype::text
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid=c.conrelid
+		JOIN pg_namespace n ON n.oid=t.relnamespace
+		LEFT JOIN pg_class ft ON ft.oid=c.confrelid
+		WHERE n.nspname=current_schema() AND t.relname=$1 AND c.contype IN ('p', 'u', 'f')
+	`
+	listIndexesPostgres = `
+		SELECT
+			i.relname,
+			ix.indisunique,
+			array_to_string(ARRAY(
+				SELECT a.attname FROM unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid=t.oid AND a.attnum=k.attnum
+				ORDER BY k.ord
+			), ',')
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid=ix.indrelid
+		JOIN pg_class i ON i.oid=ix.indexrelid
+		JOIN pg_namespace n ON n.oid=t.relnamespace
+		WHERE n.nspname=current_schema() AND t.relname=$1 AND NOT EXISTS(
+			SELECT 1 FROM pg_constraint c WHERE c.conindid=ix.indexrelid AND c.contype IN ('p', 'u', 'x')
+		)
+	`
+
+	listTablesSQLite     = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\'`
+	listColumnsSQLite    = `SELECT name, type, "notnull", pk FROM pragma_table_info($1) ORDER BY cid`
+	listIndexesSQLite    = `SELECT name, "unique", origin FROM pragma_index_list($1)`
+	listIndexColsSQLite  = `SELECT name FROM pragma_index_info($1) ORDER BY seqno`
+	listForeignKeySQLite = `SELECT id, "table", "from", "to", on_update, on_delete FROM pragma_foreign_key_list($1) ORDER BY id, seq`
+)
+
+func splitColumnList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// onDemandTables are internal tables that are created when the corresponding feature is first used
+// rather than by upgrades, so they're never included in the schema returned by [Database.DumpSchema].
+var onDemandTables = []string{sqliteNotificationTable, sqliteUpgradeLockTable}
+
+// isVersionTable checks if the table has the layout of a version table created by [Database.Upgrade].
+// This is used to recognize the version tables of child databases (see [Database.Child]), whose names
+// aren't known to the parent.
+func (table *SchemaTable) isVersionTable() bool {
+	return len(table.Columns) == 2 &&
+		table.Columns[0].Name == "version" && table.Columns[0].Type == "integer" &&
+		table.Columns[1].Name == "compat" && table.Columns[1].Type == "integer"
+}
+
+// versionTrackingTables returns the names of the tables in the schema that are used to track the schema
+// version: all version tables, database_owner and the upgrade history table. They're part of the schema,
+// but don't contain any application data.
+func (db *Database) versionTrackingTables(schema *Schema) []string {
+	tables := []string{db.VersionTable, "database_owner"}
+	if db.UpgradeHistoryTable != "" {
+		tables = append(tables, db.UpgradeHistoryTable)
+	}
+	for _, table := range schema.Tables {
+		if table.isVersionTable() && !slices.Contains(tables, table.Name) {
+			tables = append(tables, table.Name)
+		}
+	}
+	return tables
+}
+
+// DumpSchema returns a normalized description of the tables, columns, indexes and constraints in the database.
+//
+// On Postgres, only tables in the current schema are included. Tables that dbutil creates on demand,
+// like the one used by [Database.Notify] on SQLite, are never included.
+func (db *Database) DumpSchema(ctx context.Context) (*Schema, error) {
+	var tableQuery string
+	var dumpTable func(context.Context, *SchemaTable) error
+	switch db.Dialect {
+	case Postgres:
+		tableQuery = listTablesPostgres
+		dumpTable = db.dumpTablePostgres
+	case SQLite:
+		tableQuery = listTablesSQLite
+		dumpTable = db.dumpTableSQLite
+	default:
+		return nil, ErrUnsupportedDialect
+	}
+	tableNames, err := ConvertRowFn[string](ScanSingleColumn[string]).NewRowIter(db.Query(ctx, tableQuery)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	tableNames = slices.DeleteFunc(tableNames, func(name string) bool {
+		return slices.Contains(onDemandTables, name)
+	})
+	slices.Sort(tableNames)
+	schema := &Schema{Tables: make([]*SchemaTable, len(tableNames))}
+	for i, name := range tableNames {
+		table := &SchemaTable{Name: name}
+		if err = dumpTable(ctx, table); err != nil {
+			return nil, fmt.Errorf("failed to dump table %s: %w", name, err)
+		}
+		slices.SortFunc(table.Constraints, func(a, b SchemaConstraint) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		slices.SortFunc(table.Indexes, func(a, b SchemaIndex) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		schema.Tables[i] = table
+	}
+	return schema, nil
+}
+
+func (db *Database) dumpTablePostgres(ctx context.Context, table *SchemaTable) (err error) {
+	table.Columns, err = ConvertRowFn[SchemaColumn](func(row Scannable) (col SchemaColumn, err error) {
+		err = row.Scan(&col.Name, &col.Type, &col.NotNull)
+		col.Type = normalizeColumnType(col.Type)
+		return
+	}).NewRowIter(db.Query(ctx, listColumnsPostgres, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list columns: %w", err)
+	}
+	table.Constraints, err = ConvertRowFn[SchemaConstraint](func(row Scannable) (sc SchemaConstraint, err error) {
+		var conType, columns, refColumns, onUpdate, onDelete string
+		err = row.Scan(&conType, &columns, &sc.RefTable, &refColumns, &onUpdate, &onDelete)
+		sc.Columns = splitColumnList(columns)
+		switch conType {
+		case "p":
+			sc.Type = SchemaConstraintPrimaryKey
+		case "u":
+			sc.Type = SchemaConstraintUnique
+		case "f":
+			sc.Type = SchemaConstraintForeignKey
+			sc.RefColumns = splitColumnList(refColumns)
+			sc.OnUpdate = postgresForeignKeyActions[onUpdate]
+			sc.OnDelete = postgresForeignKeyActions[onDelete]
+		}
+		return
+	}).NewRowIter(db.Query(ctx, listConstraintsPostgres, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list constraints: %w", err)
+	}
+	table.Indexes, err = ConvertRowFn[SchemaIndex](func(row Scannable) (idx SchemaIndex, err error) {
+		var columns string
+		err = row.Scan(&idx.Name, &idx.Unique, &columns)
+		idx.Columns = splitColumnList(columns)
+		return
+	}).NewRowIter(db.Query(ctx, listIndexesPostgres, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) sqlitePrimaryKey(ctx context.Context, table string) (columns []string, err error) {
+	type pkColumn struct {
+		name string
+		pk   int
+	}
+	pkColumns, err := ConvertRowFn[pkColumn](func(row Scannable) (col pkColumn, err error) {
+		var typ string
+		var notNull bool
+		err = row.Scan(&col.name, &typ, &notNull, &col.pk)
+		return
+	}).NewRowIter(db.Query(ctx, listColumnsSQLite, table)).AsList()
+	if err != nil {
+		return nil, err
+	}
+	pkColumns = slices.DeleteFunc(pkColumns, func(col pkColumn) bool {
+		return col.pk == 0
+	})
+	slices.SortFunc(pkColumns, func(a, b pkColumn) int {
+		return a.pk - b.pk
+	})
+	for _, col := range pkColumns {
+		columns = append(columns, col.name)
+	}
+	return
+}
+
+func (db *Database) dumpTableSQLite(ctx context.Context, table *SchemaTable) (err error) {
+	table.Columns, err = ConvertRowFn[SchemaColumn](func(row Scannable) (col SchemaColumn, err error) {
+		var pk int
+		err = row.Scan(&col.Name, &col.Type, &col.NotNull, &pk)
+		col.Type = normalizeColumnType(col.Type)
+		// Postgres always reports primary key columns as non-nullable
+		col.NotNull = col.NotNull || pk > 0
+		return
+	}).NewRowIter(db.Query(ctx, listColumnsSQLite, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list columns: %w", err)
+	}
+	if pk, err := db.sqlitePrimaryKey(ctx, table.Name); err != nil {
+		return fmt.Errorf("failed to get primary key: %w", err)
+	} else if len(pk) > 0 {
+		table.Constraints = append(table.Constraints, SchemaConstraint{Type: SchemaConstraintPrimaryKey, Columns: pk})
+	}
+
+	type sqliteIndex struct {
+		name   string
+		unique bool
+		origin string
+	}
+	indexes, err := ConvertRowFn[sqliteIndex](func(row Scannable) (idx sqliteIndex, err error) {
+		err = row.Scan(&idx.name, &idx.unique, &idx.origin)
+		return
+	}).NewRowIter(db.Query(ctx, listIndexesSQLite, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	for _, idx := range indexes {
+		if idx.origin == "pk" {
+			continue
+		}
+		columns, err := ConvertRowFn[sql.NullString](ScanSingleColumn[sql.NullString]).NewRowIter(db.Query(ctx, listIndexColsSQLite, idx.name)).AsList()
+		if err != nil {
+			return fmt.Errorf("failed to list columns of index %s: %w", idx.name, err)
+		}
+		var columnNames []string
+		for _, col := range columns {
+			// Expression columns don't have names
+			if col.Valid {
+				columnNames = append(columnNames, col.String)
+			}
+		}
+		if idx.origin == "u" {
+			table.Constraints = append(table.Constraints, SchemaConstraint{Type: SchemaConstraintUnique, Columns: columnNames})
+		} else {
+			table.Indexes = append(table.Indexes, SchemaIndex{Name: idx.name, Unique: idx.unique, Columns: columnNames})
+		}
+	}
+
+	type sqliteForeignKeyColumn struct {
+		id       int
+		table    string
+		from     string
+		to       sql.NullString
+		onUpdate string
+		onDelete string
+	}
+	fkColumns, err := ConvertRowFn[sqliteForeignKeyColumn](func(row Scannable) (fk sqliteForeignKeyColumn, err error) {
+		err = row.Scan(&fk.id, &fk.table, &fk.from, &fk.to, &fk.onUpdate, &fk.onDelete)
+		return
+	}).NewRowIter(db.Query(ctx, listForeignKeySQLite, table.Name)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	for i, fkCol := range fkColumns {
+		if i == 0 || fkColumns[i-1].id != fkCol.id {
+			table.Constraints = append(table.Constraints, SchemaConstraint{
+				Type:     SchemaConstraintForeignKey,
+				RefTable: fkCol.table,
+				OnUpdate: fkCol.onUpdate,
+				OnDelete: fkCol.onDelete,
+			})
+		}
+		fk := &table.Constraints[len(table.Constraints)-1]
+		fk.Columns = append(fk.Columns, fkCol.from)
+		if fkCol.to.Valid {
+			fk.RefColumns = append(fk.RefColumns, fkCol.to.String)
+		}
+	}
+	for i, constraint := range table.Constraints {
+		// Foreign keys without explicit target columns reference the primary key of the target table
+		if constraint.Type == SchemaConstraintForeignKey && len(constraint.RefColumns) == 0 {
+			table.Constraints[i].RefColumns, err = db.sqlitePrimaryKey(ctx, constraint.RefTable)
+			if err != nil {
+				return fmt.Errorf("failed to get primary key of %s: %w", constraint.RefTable, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SchemaDriftError is returned by [Database.CheckSchemaSnapshot] if the database schema doesn't match the snapshot.
+type SchemaDriftError struct {
+	Path string
+	Diff []string
+}
+
+func (sde *SchemaDriftError) Error() string {
+	return fmt.Sprintf("database schema doesn't match snapshot %s:\n%s", sde.Path, strings.Join(sde.Diff, "\n"))
+}
+
+// UpdateSchemaSnapshotsEnv is the environment variable that makes [Database.CheckSchemaSnapshot]
+// overwrite existing snapshots instead of comparing against them.
+const UpdateSchemaSnapshotsEnv = "DBUTIL_UPDATE_SCHEMA_SNAPSHOTS"
+
+// CheckSchemaSnapshot compares the current schema of the database to the snapshot stored in the given file.
+//
+// This is meant to be used in tests after running all upgrades on a fresh database. If the snapshot file
+// doesn't exist or the environment variable named by [UpdateSchemaSnapshotsEnv] is set to a non-empty
+// value, the snapshot is written instead. If the schema doesn't match, a [*SchemaDriftError] is returned.
+//
+// Because the schema is normalized, the same snapshot can be used for both Postgres and SQLite.
+func (db *Database) CheckSchemaSnapshot(ctx context.Context, path string) error {
+	schema, err := db.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+	current := schema.String()
+	snapshot, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && os.Getenv(UpdateSchemaSnapshotsEnv) != "") {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create snapshot directory: %w", err)
+		}
+		return os.WriteFile(path, []byte(current), 0644)
+	} else if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if string(snapshot) == current {
+		return nil
+	}
+	diff := diffLines(qualifySnapshotLines(string(snapshot)), schema.qualifiedLines())
+	if len(diff) == 0 {
+		// Only the order of lines changed
+		diff = []string{"(order of lines differs)"}
+	}
+	return &SchemaDriftError{Path: path, Diff: diff}
+}
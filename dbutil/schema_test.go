@@ -0,0 +1,137 @@
+package dbutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchemaSQL = `
+CREATE TABLE parent (
+	id   INTEGER PRIMARY KEY,
+	name TEXT    NOT NULL UNIQUE
+);
+CREATE TABLE child (
+	parent_id INTEGER NOT NULL,
+	idx       BIGINT  NOT NULL,
+	data      jsonb,
+	flag      BOOLEAN NOT NULL DEFAULT false,
+	PRIMARY KEY (parent_id, idx),
+	CONSTRAINT child_parent_fkey FOREIGN KEY (parent_id) REFERENCES parent ON DELETE CASCADE
+);
+CREATE INDEX child_flag_idx ON child (flag, idx);
+`
+
+const expectedTestSchema = `table child
+	column parent_id integer not null
+	column idx integer not null
+	column data json
+	column flag boolean not null
+	foreign key (parent_id) references parent (id) on update NO ACTION on delete CASCADE
+	primary key (parent_id, idx)
+	index child_flag_idx (flag, idx)
+
+table parent
+	column id integer not null
+	column name text not null
+	primary key (id)
+	unique (name)
+`
+
+func newSchemaTestDB(t *testing.T) *Database {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	_, err = db.Exec(context.Background(), testSchemaSQL)
+	require.NoError(t, err)
+	return db
+}
+
+func TestDatabase_DumpSchema(t *testing.T) {
+	db := newSchemaTestDB(t)
+	schema, err := db.DumpSchema(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expectedTestSchema, schema.String())
+	require.NotNil(t, schema.Table("parent"))
+	assert.Nil(t, schema.Table("meow"))
+}
+
+func TestDatabase_CheckSchemaSnapshot(t *testing.T) {
+	ctx := context.Background()
+	db := newSchemaTestDB(t)
+	path := filepath.Join(t.TempDir(), "schema.txt")
+	require.NoError(t, db.CheckSchemaSnapshot(ctx, path))
+	snapshot, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expectedTestSchema, string(snapshot))
+	require.NoError(t, db.CheckSchemaSnapshot(ctx, path))
+
+	_, err = db.Exec(ctx, "ALTER TABLE parent ADD COLUMN extra TEXT")
+	require.NoError(t, err)
+	err = db.CheckSchemaSnapshot(ctx, path)
+	var driftErr *SchemaDriftError
+	require.ErrorAs(t, err, &driftErr)
+	assert.Equal(t, []string{"+parent: column extra text"}, driftErr.Diff)
+
+	t.Setenv(UpdateSchemaSnapshotsEnv, "1")
+	require.NoError(t, db.CheckSchemaSnapshot(ctx, path))
+	t.Setenv(UpdateSchemaSnapshotsEnv, "")
+	require.NoError(t, db.CheckSchemaSnapshot(ctx, path))
+}
+
+func TestSchema_Diff(t *testing.T) {
+	old := &Schema{Tables: []*SchemaTable{{
+		Name:    "foo",
+		Columns: []SchemaColumn{{Name: "id", Type: "integer", NotNull: true}},
+	}}}
+	new := &Schema{Tables: []*SchemaTable{{
+		Name:    "foo",
+		Columns: []SchemaColumn{{Name: "id", Type: "text", NotNull: true}},
+	}}}
+	assert.Equal(t, []string{
+		"-foo: column id integer not null",
+		"+foo: column id text not null",
+	}, old.Diff(new))
+	assert.Empty(t, old.Diff(old))
+}
+
+func TestCheckSchemaSnapshot_SameColumnInTwoTables(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	_, err = db.Exec(ctx, "CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT); CREATE TABLE b (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "schema.txt")
+	require.NoError(t, db.CheckSchemaSnapshot(ctx, path))
+	// The same line still exists in table a, but it was added to b
+	_, err = db.Exec(ctx, "ALTER TABLE b ADD COLUMN name TEXT")
+	require.NoError(t, err)
+	var driftErr *SchemaDriftError
+	require.ErrorAs(t, db.CheckSchemaSnapshot(ctx, path), &driftErr)
+	assert.Equal(t, []string{"+b: column name text"}, driftErr.Diff)
+}
+
+func TestNormalizeColumnType(t *testing.T) {
+	for input, expected := range map[string]string{
+		"INTEGER":          "integer",
+		"bigint":           "integer",
+		"int8":             "integer",
+		"BIGSERIAL":        "integer",
+		"UNSIGNED BIG INT": "integer",
+		"point":            "point",
+		"interval":         "interval",
+		"int4range":        "int4range",
+		"varchar(255)":     "text",
+		"":                 "any",
+	} {
+		assert.Equal(t, expected, normalizeColumnType(input), input)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/util/exsync"
+)
+
+var (
+	ErrInvalidSchemaName     = errors.New("invalid schema name")
+	ErrScopedChildNoURI      = errors.New("database wasn't opened with a URI, can't create scoped child")
+	ErrScopedChildMemoryOnly = errors.New("can't create scoped child of in-memory SQLite database")
+)
+
+var schemaNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// ScopedChildParentAlias is the name the parent database is attached as in scoped SQLite children.
+const ScopedChildParentAlias = "parent"
+
+// ScopedChild creates a child database whose tables live in a separate namespace,
+// so that multiple modules can share one database without table name collisions.
+//
+// On Postgres, the namespace is a schema with the given name, which is created if it doesn't exist.
+// All connections of the child have their search_path set to only that schema.
+//
+// On SQLite, the namespace is a separate database file next to the main database file,
+// named <main file name>-<schema>.<ext>. The child's connections use that file as the main
+// database and attach the parent's database as "parent" (see [ScopedChildParentAlias]).
+//
+// Unlike [Database.Child], the scoped child has its own connection pool (with the same maximum
+// number of connections as the parent), so transactions aren't shared with the parent and the
+// child must be closed separately. The parent must have been created with [NewWithDialect] or
+// [NewFromConfig], as the connection URI is needed to open the new pool.
+func (db *Database) ScopedChild(ctx context.Context, schema, versionTable string, upgradeTable UpgradeTable, log DatabaseLogger) (*Database, error) {
+	if !schemaNameRegex.MatchString(schema) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSchemaName, schema)
+	} else if db.uri == "" || db.driverName == "" {
+		return nil, ErrScopedChildNoURI
+	}
+	var uri string
+	var initQueries []string
+	switch db.Dialect {
+	case Postgres:
+		_, err := db.Exec(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, schema))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+		uri = db.uri
+		initQueries = []string{fmt.Sprintf(`SET search_path TO "%s"`, schema)}
+	case SQLite:
+		var mainPath string
+		err := db.QueryRow(ctx, "SELECT file FROM pragma_database_list WHERE name='main'").Scan(&mainPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get main database path: %w", err)
+		} else if mainPath == "" {
+			return nil, ErrScopedChildMemoryOnly
+		}
+		ext := filepath.Ext(mainPath)
+		childPath := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(mainPath, ext), schema, ext)
+		uri = "file:" + childPath
+		if _, params, ok := strings.Cut(db.uri, "?"); ok {
+			uri += "?" + params
+		}
+		initQueries = []string{fmt.Sprintf(
+			`ATTACH DATABASE '%s' AS %s`, strings.ReplaceAll(mainPath, "'", "''"), ScopedChildParentAlias,
+		)}
+	default:
+		return nil, ErrUnsupportedDialect
+	}
+
+	var connector driver.Connector
+	drv := db.RawDB.Driver()
+	if drvCtx, ok := drv.(driver.DriverContext); ok {
+		var err error
+		connector, err = drvCtx.OpenConnector(uri)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		connector = &dsnConnector{dsn: uri, driver: drv}
+	}
+	rawDB := sql.OpenDB(&initConnector{Connector: connector, init: initQueries})
+	rawDB.SetMaxOpenConns(db.RawDB.Stats().MaxOpenConnections)
+
+	if log == nil {
+		log = db.Log
+	}
+	child := &Database{
+		RawDB:        rawDB,
+		VersionTable: versionTable,
+		UpgradeTable: upgradeTable,
+		Log:          log,
+		Dialect:      db.Dialect,
+
+		LockUpgrades:       db.LockUpgrades,
+		UpgradeLockTimeout: db.UpgradeLockTimeout,
+
+		txnCtxKey:      contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+		txnDeadlockMap: exsync.NewSet[int64](),
+		uri:            uri,
+		driverName:     db.driverName,
+
+		IgnoreForeignTables:       true,
+		IgnoreUnsupportedDatabase: db.IgnoreUnsupportedDatabase,
+		DeadlockDetection:         db.DeadlockDetection,
+		Metrics:                   db.Metrics,
+	}
+	child.LoggingDB.UnderlyingExecable = rawDB
+	child.LoggingDB.db = child
+	if err := rawDB.PingContext(ctx); err != nil {
+		_ = rawDB.Close()
+		return nil, fmt.Errorf("failed to connect to scoped child database: %w", err)
+	}
+	return child, nil
+}
+
+// dsnConnector is a [driver.Connector] for drivers that don't implement [driver.DriverContext].
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (dc *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return dc.driver.Open(dc.dsn)
+}
+
+func (dc *dsnConnector) Driver() driver.Driver {
+	return dc.driver
+}
+
+// initConnector is a [driver.Connector] that runs the given queries on every new connection.
+type initConnector struct {
+	driver.Connector
+	init []string
+}
+
+func (ic *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := ic.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		_ = conn.Close()
+		return nil, fmt.Errorf("can't run connection init queries on a %T", conn)
+	}
+	for _, query := range ic.init {
+		_, err = execer.ExecContext(ctx, query, nil)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to run connection init query %q: %w", query, err)
+		}
+	}
+	return conn, nil
+}
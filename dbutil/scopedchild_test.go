@@ -0,0 +1,57 @@
+package dbutil
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_ScopedChild_SQLite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewWithDialect("file:"+filepath.Join(dir, "main.db")+"?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (value TEXT NOT NULL); INSERT INTO foo VALUES ('parent')")
+	require.NoError(t, err)
+
+	upgradeTable := BuildUpgradeTable().WithRaw(0, 1, 0, "Create foo", TxnModeOn, func(ctx context.Context, db *Database) error {
+		_, err := db.Exec(ctx, "CREATE TABLE foo (value TEXT NOT NULL, extra INTEGER)")
+		return err
+	}).Finish()
+	child, err := db.ScopedChild(ctx, "module", "module_version", upgradeTable, nil)
+	require.NoError(t, err)
+	defer child.Close()
+	require.NoError(t, child.Upgrade(ctx))
+	_, err = child.Exec(ctx, "INSERT INTO foo VALUES ('child', 1)")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "main-module.db"))
+
+	var value string
+	require.NoError(t, db.QueryRow(ctx, "SELECT value FROM foo").Scan(&value))
+	assert.Equal(t, "parent", value)
+	require.NoError(t, child.QueryRow(ctx, "SELECT value FROM foo").Scan(&value))
+	assert.Equal(t, "child", value)
+	require.NoError(t, child.QueryRow(ctx, "SELECT value FROM parent.foo").Scan(&value))
+	assert.Equal(t, "parent", value)
+
+	var exists bool
+	require.NoError(t, db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name='module_version')").Scan(&exists))
+	assert.False(t, exists)
+}
+
+func TestDatabase_ScopedChild_Errors(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.ScopedChild(ctx, "Bad-Name", "v", UpgradeTable{}, nil)
+	assert.ErrorIs(t, err, ErrInvalidSchemaName)
+	_, err = db.ScopedChild(ctx, "module", "v", UpgradeTable{}, nil)
+	assert.ErrorIs(t, err, ErrScopedChildMemoryOnly)
+	_, err = (&Database{Dialect: SQLite}).ScopedChild(ctx, "module", "v", UpgradeTable{}, nil)
+	assert.ErrorIs(t, err, ErrScopedChildNoURI)
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+)
+
+// StatementCacheStats contains counters for the prepared statement cache of a [Database].
+type StatementCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type stmtCacheKey struct {
+	db    *sql.DB
+	query string
+}
+
+type cachedStmt struct {
+	key          stmtCacheKey
+	mutatedQuery string
+	stmt         *sql.Stmt
+
+	refs    int
+	evicted bool
+}
+
+type stmtCache struct {
+	lock    sync.Mutex
+	maxSize int
+	entries map[stmtCacheKey]*list.Element
+	lru     *list.List
+	stats   StatementCacheStats
+}
+
+// EnableStatementCache enables a cache of prepared statements with the given maximum size.
+// A size of zero or less disables the cache.
+//
+// When the cache is enabled, queries with parameters are automatically prepared and the prepared
+// statements are reused for subsequent calls with the same query. Statements are only prepared
+// outside transactions (to avoid needing another connection while a transaction is holding one),
+// but cached statements are also used inside transactions.
+//
+// Children created with [Database.Child] after this call share the same cache.
+//
+// Prepared statements depend on the schema, so Postgres may fail to execute them with "cached plan must
+// not change result type" after DDL. The cache is cleared automatically after [Database.Upgrade] and
+// [Database.Downgrade], but it must be cleared manually with [Database.ClearStatementCache] after
+// changing the schema in other ways.
+func (db *Database) EnableStatementCache(size int) {
+	oldCache := db.stmtCache
+	if size > 0 {
+		db.stmtCache = &stmtCache{
+			maxSize: size,
+			entries: make(map[stmtCacheKey]*list.Element, size),
+			lru:     list.New(),
+		}
+	} else {
+		db.stmtCache = nil
+	}
+	if oldCache != nil {
+		oldCache.close()
+	}
+}
+
+// ClearStatementCache closes all prepared statements in the statement cache. The cache stays enabled.
+func (db *Database) ClearStatementCache() {
+	if db.stmtCache != nil {
+		db.stmtCache.close()
+	}
+}
+
+// StatementCacheStats returns the hit and miss counts of the prepared statement cache.
+func (db *Database) StatementCacheStats() StatementCacheStats {
+	if db.stmtCache == nil {
+		return StatementCacheStats{}
+	}
+	db.stmtCache.lock.Lock()
+	defer db.stmtCache.lock.Unlock()
+	stats := db.stmtCache.stats
+	stats.Size = db.stmtCache.lru.Len()
+	return stats
+}
+
+func (sc *stmtCache) get(key stmtCacheKey) *cachedStmt {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	elem, ok := sc.entries[key]
+	if !ok {
+		sc.stats.Misses++
+		return nil
+	}
+	sc.stats.Hits++
+	sc.lru.MoveToFront(elem)
+	entry := elem.Value.(*cachedStmt)
+	entry.refs++
+	return entry
+}
+
+func (sc *stmtCache) put(entry *cachedStmt) *cachedStmt {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if elem, ok := sc.entries[entry.key]; ok {
+		// Another goroutine prepared the same query concurrently, use the existing one
+		_ = entry.stmt.Close()
+		existing := elem.Value.(*cachedStmt)
+		existing.refs++
+		return existing
+	}
+	entry.refs++
+	sc.entries[entry.key] = sc.lru.PushFront(entry)
+	for sc.lru.Len() > sc.maxSize {
+		oldest := sc.lru.Back()
+		sc.evict(oldest)
+		sc.stats.Evictions++
+	}
+	return entry
+}
+
+func (sc *stmtCache) evict(elem *list.Element) {
+	entry := elem.Value.(*cachedStmt)
+	sc.lru.Remove(elem)
+	delete(sc.entries, entry.key)
+	entry.evicted = true
+	if entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+func (sc *stmtCache) release(entry *cachedStmt) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+func (sc *stmtCache) close() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	for sc.lru.Len() > 0 {
+		sc.evict(sc.lru.Back())
+	}
+}
+
+// cachedStmt returns a prepared statement for the given query from the statement cache.
+// If the returned release function is non-nil, it must be called after the statement has been executed.
+func (le *LoggingExecable) cachedStmt(ctx context.Context, query string, args []any) (stmt *sql.Stmt, mutatedQuery string, release func()) {
+	cache := le.db.stmtCache
+	// Queries with multiple statements can't be prepared, as only the first statement would be executed
+	if cache == nil || len(args) == 0 || strings.Contains(strings.TrimRight(query, "; \t\n"), ";") {
+		return nil, le.db.mutateQuery(query), nil
+	}
+	var baseDB *sql.DB
+	var tx *sql.Tx
+	switch underlying := le.UnderlyingExecable.(type) {
+	case *sql.DB:
+		baseDB = underlying
+	case *sql.Tx:
+		baseDB, tx = le.baseDB, underlying
+	}
+	if baseDB == nil {
+		return nil, le.db.mutateQuery(query), nil
+	}
+	key := stmtCacheKey{db: baseDB, query: query}
+	entry := cache.get(key)
+	if entry == nil {
+		mutatedQuery = le.db.mutateQuery(query)
+		if tx != nil {
+			return nil, mutatedQuery, nil
+		}
+		prepared, err := baseDB.PrepareContext(ctx, mutatedQuery)
+		if err != nil {
+			// Let the normal query path return the error
+			return nil, mutatedQuery, nil
+		}
+		entry = cache.put(&cachedStmt{key: key, mutatedQuery: mutatedQuery, stmt: prepared})
+	}
+	stmt = entry.stmt
+	if tx != nil {
+		stmt = tx.StmtContext(ctx, stmt)
+	}
+	return stmt, entry.mutatedQuery, func() {
+		cache.release(entry)
+	}
+}
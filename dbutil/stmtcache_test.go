@@ -0,0 +1,89 @@
+package dbutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_StatementCache(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.EnableStatementCache(2)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE foo (key INTEGER PRIMARY KEY, value TEXT NOT NULL)")
+	require.NoError(t, err)
+	assert.Equal(t, StatementCacheStats{}, db.StatementCacheStats())
+
+	const insertQuery = "INSERT INTO foo (key, value) VALUES ($1, $2)"
+	const selectQuery = "SELECT value FROM foo WHERE key=$1"
+	for i := range 3 {
+		_, err = db.Exec(ctx, insertQuery, i, "meow")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, StatementCacheStats{Hits: 2, Misses: 1, Size: 1}, db.StatementCacheStats())
+
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := db.Exec(ctx, insertQuery, 3, "hmm")
+		require.NoError(t, err)
+		// Uncached queries aren't prepared inside transactions
+		var value string
+		require.NoError(t, db.QueryRow(ctx, selectQuery, 3).Scan(&value))
+		assert.Equal(t, "hmm", value)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatementCacheStats{Hits: 3, Misses: 2, Size: 1}, db.StatementCacheStats())
+
+	var value string
+	require.NoError(t, db.QueryRow(ctx, selectQuery, 2).Scan(&value))
+	assert.Equal(t, "meow", value)
+	rows, err := db.Query(ctx, "SELECT key FROM foo WHERE value=$1 ORDER BY key", "meow")
+	require.NoError(t, err)
+	keys, err := ConvertRowFn[int](ScanSingleColumn[int]).NewRowIter(rows, err).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, keys)
+	assert.Equal(t, StatementCacheStats{Hits: 3, Misses: 4, Evictions: 1, Size: 2}, db.StatementCacheStats())
+
+	require.NoError(t, db.Close())
+}
+
+func TestDatabase_StatementCache_ConfigureWithoutSize(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.EnableStatementCache(2)
+	require.NoError(t, db.Configure(Config{PoolConfig: PoolConfig{MaxOpenConns: 1}}))
+	require.NotNil(t, db.stmtCache)
+	assert.Equal(t, 2, db.stmtCache.maxSize)
+
+	require.NoError(t, db.Configure(Config{PoolConfig: PoolConfig{MaxOpenConns: 1}, StatementCacheSize: -1}))
+	assert.Nil(t, db.stmtCache)
+	require.NoError(t, db.Close())
+}
+
+func TestDatabase_StatementCache_ClearedAfterUpgrade(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.EnableStatementCache(2)
+	ctx := context.Background()
+	db.UpgradeTable = BuildUpgradeTable().WithRaw(0, 1, 0, "create table", TxnModeOn, func(ctx context.Context, db *Database) error {
+		_, err := db.Exec(ctx, "CREATE TABLE foo (key INTEGER PRIMARY KEY)")
+		return err
+	}).Finish()
+	require.NoError(t, db.Upgrade(ctx))
+	_, err = db.Exec(ctx, "INSERT INTO foo (key) VALUES ($1)", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.StatementCacheStats().Size)
+
+	db.UpgradeTable = append(db.UpgradeTable, WrapUpgrade(1, 2, 0, "add column", TxnModeOn, func(ctx context.Context, db *Database) error {
+		_, err := db.Exec(ctx, "ALTER TABLE foo ADD COLUMN value TEXT")
+		return err
+	}))
+	require.NoError(t, db.Upgrade(ctx))
+	assert.Equal(t, 0, db.StatementCacheStats().Size)
+	require.NoError(t, db.Close())
+}
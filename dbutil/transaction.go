@@ -32,8 +32,26 @@ type contextKey int64
 
 const (
 	ContextKeyDoTxnCallerSkip contextKey = 1
+
+	contextKeyReadOnlyRouting contextKey = 2
 )
 
+// ForcePrimaryDB returns a context that makes Query and QueryRow calls use the primary
+// database pool even if [Database.AutoReadOnly] is enabled.
+//
+// This is useful for reads that must see writes made immediately before them,
+// or for queries that aren't actually read-only (e.g. INSERT ... RETURNING).
+func ForcePrimaryDB(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyReadOnlyRouting, false)
+}
+
+// PreferReadOnlyDB returns a context that makes Query and QueryRow calls use the read-only
+// database pool even if [Database.AutoReadOnly] is not enabled. If the database doesn't have
+// a read-only pool, the primary pool is used as usual.
+func PreferReadOnlyDB(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyReadOnlyRouting, true)
+}
+
 var nextContextKeyDatabaseTransaction atomic.Uint64
 
 func init() {
@@ -45,11 +63,11 @@ func (db *Database) Exec(ctx context.Context, query string, args ...any) (sql.Re
 }
 
 func (db *Database) Query(ctx context.Context, query string, args ...any) (Rows, error) {
-	return db.Execable(ctx).QueryContext(ctx, query, args...)
+	return db.ReadExecable(ctx).QueryContext(ctx, query, args...)
 }
 
 func (db *Database) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
-	return db.Execable(ctx).QueryRowContext(ctx, query, args...)
+	return db.ReadExecable(ctx).QueryRowContext(ctx, query, args...)
 }
 
 var ErrTransactionDeadlock = errors.New("attempt to start new transaction in goroutine with transaction")
@@ -67,7 +85,9 @@ func (db *Database) DoTxn(ctx context.Context, opts *TxnOptions, fn func(ctx con
 	if ctx == nil {
 		panic("DoTxn() called with nil ctx")
 	}
-	if ctx.Value(db.txnCtxKey) != nil {
+	if txn, ok := ctx.Value(db.txnCtxKey).(*LoggingTxn); ok && opts != nil && opts.Nested {
+		return db.doSavepoint(ctx, txn, fn)
+	} else if ctx.Value(db.txnCtxKey) != nil {
 		zerolog.Ctx(ctx).Trace().Msg("Already in a transaction, not creating a new one")
 		return fn(ctx)
 	} else if db.DeadlockDetection {
@@ -115,6 +135,29 @@ func (db *Database) DoTxn(ctx context.Context, opts *TxnOptions, fn func(ctx con
 				Msg("Transaction took long")
 		}
 	}()
+	var retry *TxnRetryPolicy
+	if opts != nil {
+		retry = opts.Retry
+	}
+	for attempt := 1; ; attempt++ {
+		err := db.doTxnAttempt(ctx, log, opts, fn)
+		if err == nil || retry == nil || !retry.shouldRetry(err, attempt) {
+			return err
+		}
+		backoff := retry.backoff(attempt)
+		log.Debug().Err(err).
+			Int("attempt", attempt).
+			Dur("backoff", backoff).
+			Msg("Transaction failed with retryable error, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (db *Database) doTxnAttempt(ctx context.Context, log zerolog.Logger, opts *TxnOptions, fn func(ctx context.Context) error) error {
 	tx, err := db.BeginTx(ctx, opts)
 	if err != nil {
 		log.Trace().Err(err).Msg("Failed to begin transaction")
@@ -148,6 +191,36 @@ func (db *Database) DoTxn(ctx context.Context, opts *TxnOptions, fn func(ctx con
 	return nil
 }
 
+func (db *Database) doSavepoint(ctx context.Context, txn *LoggingTxn, fn func(ctx context.Context) error) error {
+	name := fmt.Sprintf("dbutil_savepoint_%d", txn.savepointCounter.Add(1))
+	log := zerolog.Ctx(ctx).With().Str("db_savepoint", name).Logger()
+	_, err := txn.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	log.Trace().Msg("Savepoint created")
+	commitHooks, rollbackHooks := txn.hooks.mark()
+	err = fn(log.WithContext(ctx))
+	if err != nil {
+		log.Trace().Err(err).Msg("Nested transaction failed, rolling back to savepoint")
+		_, rollbackErr := txn.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		if rollbackErr == nil {
+			_, rollbackErr = txn.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+		}
+		if rollbackErr != nil {
+			log.Warn().Err(rollbackErr).Msg("Rollback to savepoint after nested transaction error failed")
+		} else {
+			txn.hooks.rollbackTo(commitHooks, rollbackHooks)
+		}
+		return err
+	}
+	_, err = txn.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	if err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
 func (db *Database) Execable(ctx context.Context) Execable {
 	if ctx == nil {
 		panic("Conn() called with nil ctx")
@@ -162,6 +235,29 @@ func (db *Database) Execable(ctx context.Context) Execable {
 	return &db.LoggingDB
 }
 
+// ReadExecable returns the Execable that read queries with the given context should use.
+//
+// Inside transactions, this is always the transaction. Otherwise, the read-only pool is
+// returned if it's configured and either [Database.AutoReadOnly] is enabled or the
+// context was created with [PreferReadOnlyDB] (and not overridden with [ForcePrimaryDB]).
+func (db *Database) ReadExecable(ctx context.Context) Execable {
+	execable := db.Execable(ctx)
+	if db.ReadOnlyDB == nil || execable != Execable(&db.LoggingDB) {
+		return execable
+	}
+	useReadOnly := db.AutoReadOnly
+	if val, ok := ctx.Value(contextKeyReadOnlyRouting).(bool); ok {
+		useReadOnly = val
+	}
+	if !useReadOnly {
+		return execable
+	}
+	return &LoggingExecable{
+		UnderlyingExecable: db.ReadOnlyDB,
+		db:                 db,
+	}
+}
+
 func (db *Database) AcquireConn(ctx context.Context) (Conn, error) {
 	if ctx == nil {
 		return nil, fmt.Errorf("AcquireConn() called with nil ctx")
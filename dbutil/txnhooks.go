@@ -0,0 +1,86 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"sync"
+)
+
+type txnHooks struct {
+	lock     sync.Mutex
+	commit   []func()
+	rollback []func()
+}
+
+// OnCommit registers a function to be called after the transaction in the context is committed.
+// If the transaction is rolled back instead, the function is never called.
+//
+// If the context doesn't have a transaction, the function is called immediately.
+// This is useful for side effects like cache invalidation, which shouldn't happen
+// if the changes made in the transaction are thrown away.
+func (db *Database) OnCommit(ctx context.Context, fn func()) {
+	txn, ok := ctx.Value(db.txnCtxKey).(*LoggingTxn)
+	if !ok {
+		fn()
+		return
+	}
+	txn.hooks.lock.Lock()
+	txn.hooks.commit = append(txn.hooks.commit, fn)
+	txn.hooks.lock.Unlock()
+}
+
+// OnRollback registers a function to be called after the transaction in the context is rolled back
+// (or fails to commit). If the function is registered inside a nested transaction using a savepoint
+// (see [TxnOptions.Nested]), it's also called when the changes are rolled back to the savepoint.
+//
+// If the context doesn't have a transaction, this is a no-op.
+func (db *Database) OnRollback(ctx context.Context, fn func()) {
+	txn, ok := ctx.Value(db.txnCtxKey).(*LoggingTxn)
+	if !ok {
+		return
+	}
+	txn.hooks.lock.Lock()
+	txn.hooks.rollback = append(txn.hooks.rollback, fn)
+	txn.hooks.lock.Unlock()
+}
+
+// mark returns the current number of hooks, which can be passed to rollbackTo later.
+func (th *txnHooks) mark() (commit, rollback int) {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+	return len(th.commit), len(th.rollback)
+}
+
+// rollbackTo drops the hooks registered after the given mark and runs the dropped rollback hooks.
+func (th *txnHooks) rollbackTo(commit, rollback int) {
+	th.lock.Lock()
+	th.commit = th.commit[:commit]
+	rollbackHooks := th.rollback[rollback:]
+	th.rollback = th.rollback[:rollback:rollback]
+	th.lock.Unlock()
+	runTxnHooks(rollbackHooks)
+}
+
+// finish clears all hooks and runs either the commit or rollback hooks.
+func (th *txnHooks) finish(committed bool) {
+	th.lock.Lock()
+	commitHooks, rollbackHooks := th.commit, th.rollback
+	th.commit, th.rollback = nil, nil
+	th.lock.Unlock()
+	if committed {
+		runTxnHooks(commitHooks)
+	} else {
+		runTxnHooks(rollbackHooks)
+	}
+}
+
+func runTxnHooks(hooks []func()) {
+	for _, hook := range hooks {
+		hook()
+	}
+}
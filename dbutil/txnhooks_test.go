@@ -0,0 +1,53 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_OnCommit(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	var calls []string
+	hook := func(name string) func() {
+		return func() {
+			calls = append(calls, name)
+		}
+	}
+
+	db.OnCommit(ctx, hook("immediate"))
+	db.OnRollback(ctx, hook("never"))
+	assert.Equal(t, []string{"immediate"}, calls)
+
+	calls = nil
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		db.OnCommit(ctx, hook("commit 1"))
+		db.OnRollback(ctx, hook("rollback 1"))
+		_ = db.DoTxn(ctx, &TxnOptions{Nested: true}, func(ctx context.Context) error {
+			db.OnCommit(ctx, hook("commit 2"))
+			db.OnRollback(ctx, hook("rollback 2"))
+			return errors.New("meow")
+		})
+		db.OnCommit(ctx, hook("commit 3"))
+		assert.Equal(t, []string{"rollback 2"}, calls)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"rollback 2", "commit 1", "commit 3"}, calls)
+
+	calls = nil
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		db.OnCommit(ctx, hook("commit"))
+		db.OnRollback(ctx, hook("rollback"))
+		return errors.New("meow")
+	})
+	require.Error(t, err)
+	assert.Equal(t, []string{"rollback"}, calls)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// TxnRetryPolicy specifies how [Database.DoTxn] should retry transactions that fail due to
+// serialization failures, deadlocks or lock contention.
+//
+// When a transaction is retried, it's rolled back and the entire callback function is called again
+// with a new transaction, so the callback must not have side effects outside the database.
+type TxnRetryPolicy struct {
+	// MaxAttempts is the total number of times the transaction may be attempted, including the first attempt.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. The delay is doubled after each retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries.
+	MaxBackoff time.Duration
+	// ShouldRetry can be used to override which errors are retried. Defaults to [IsRetryableTxnError].
+	ShouldRetry func(err error) bool
+}
+
+// DefaultTxnRetryPolicy is a reasonable retry policy that can be used in [TxnOptions.Retry].
+var DefaultTxnRetryPolicy = &TxnRetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+func (trp *TxnRetryPolicy) shouldRetry(err error, attempt int) bool {
+	if attempt >= trp.MaxAttempts {
+		return false
+	} else if trp.ShouldRetry != nil {
+		return trp.ShouldRetry(err)
+	}
+	return IsRetryableTxnError(err)
+}
+
+// backoff returns the delay before the given retry attempt with jitter applied.
+func (trp *TxnRetryPolicy) backoff(attempt int) time.Duration {
+	backoff := trp.InitialBackoff
+	for i := 1; i < attempt && (trp.MaxBackoff <= 0 || backoff < trp.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if trp.MaxBackoff > 0 {
+		backoff = min(backoff, trp.MaxBackoff)
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	// Use a random duration between half and the full backoff so that concurrent transactions don't retry in lockstep
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// IsRetryableTxnError checks if the given error is a transient error caused by concurrent
+// transactions, which means the transaction can be retried from the beginning.
+//
+// On Postgres, this includes serialization failures (40001) and deadlocks (40P01).
+// On SQLite, this includes SQLITE_BUSY and SQLITE_LOCKED.
+func IsRetryableTxnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sqlStateErr interface{ SQLState() string }
+	if errors.As(err, &sqlStateErr) {
+		state := sqlStateErr.SQLState()
+		return state == sqlStateSerializationFailure || state == sqlStateDeadlockDetected
+	}
+	var codeErr interface{ Code() int }
+	if errors.As(err, &codeErr) {
+		// Extended result codes have the primary code in the lowest byte
+		code := codeErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+	// mattn/go-sqlite3 doesn't have any methods for getting the error code
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
@@ -0,0 +1,88 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSQLStateError string
+
+func (f fakeSQLStateError) Error() string    { return "sql state " + string(f) }
+func (f fakeSQLStateError) SQLState() string { return string(f) }
+
+type fakeCodeError int
+
+func (f fakeCodeError) Error() string { return fmt.Sprintf("code %d", int(f)) }
+func (f fakeCodeError) Code() int     { return int(f) }
+
+func TestIsRetryableTxnError(t *testing.T) {
+	assert.False(t, IsRetryableTxnError(nil))
+	assert.True(t, IsRetryableTxnError(fakeSQLStateError("40001")))
+	assert.True(t, IsRetryableTxnError(fmt.Errorf("wrapped: %w", fakeSQLStateError("40P01"))))
+	assert.False(t, IsRetryableTxnError(fakeSQLStateError("23505")))
+	assert.True(t, IsRetryableTxnError(fakeCodeError(5)))
+	// SQLITE_BUSY_SNAPSHOT
+	assert.True(t, IsRetryableTxnError(fakeCodeError(517)))
+	assert.False(t, IsRetryableTxnError(fakeCodeError(19)))
+	assert.True(t, IsRetryableTxnError(errors.New("database is locked")))
+	assert.False(t, IsRetryableTxnError(errors.New("UNIQUE constraint failed")))
+}
+
+func TestTxnRetryPolicy_Backoff(t *testing.T) {
+	policy := &TxnRetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for range 100 {
+		first := policy.backoff(1)
+		assert.True(t, first >= 50*time.Millisecond && first <= 100*time.Millisecond)
+		capped := policy.backoff(10)
+		assert.True(t, capped >= 150*time.Millisecond && capped <= 300*time.Millisecond)
+	}
+}
+
+func TestDatabase_DoTxn_Retry(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	_, err = db.Exec(ctx, "CREATE TABLE counter (value INTEGER NOT NULL)")
+	require.NoError(t, err)
+	opts := &TxnOptions{Retry: &TxnRetryPolicy{MaxAttempts: 3}}
+
+	attempts := 0
+	err = db.DoTxn(ctx, opts, func(ctx context.Context) error {
+		attempts++
+		_, err := db.Exec(ctx, "INSERT INTO counter (value) VALUES ($1)", attempts)
+		require.NoError(t, err)
+		if attempts < 3 {
+			return errors.New("database is locked")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	var values []int
+	values, err = ConvertRowFn[int](ScanSingleColumn[int]).NewRowIter(db.Query(ctx, "SELECT value FROM counter")).AsList()
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, values)
+
+	attempts = 0
+	err = db.DoTxn(ctx, opts, func(ctx context.Context) error {
+		attempts++
+		return errors.New("database is locked")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = db.DoTxn(ctx, opts, func(ctx context.Context) error {
+		attempts++
+		return errors.New("meow")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const createUpgradeHistoryTable = `
+CREATE TABLE IF NOT EXISTS %s (
+	from_version INTEGER NOT NULL,
+	to_version   INTEGER NOT NULL,
+	compat       INTEGER NOT NULL,
+	message      TEXT    NOT NULL,
+	checksum     TEXT,
+	started_at   BIGINT  NOT NULL,
+	duration_ms  BIGINT  NOT NULL,
+	app_version  TEXT    NOT NULL
+)
+`
+
+// ChecksumMismatch is a previously applied upgrade whose SQL has changed since it was applied.
+type ChecksumMismatch struct {
+	From    int
+	To      int
+	Message string
+
+	Applied string
+	Current string
+}
+
+func (upg *Upgrade) checksum(db *Database) (sql.NullString, error) {
+	if upg.sql == nil {
+		return sql.NullString{}, nil
+	}
+	query, _, err := upg.sql(db)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	hash := sha256.Sum256([]byte(query))
+	return sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true}, nil
+}
+
+func (db *Database) ensureUpgradeHistoryTable(ctx context.Context) error {
+	if db.UpgradeHistoryTable == "" {
+		return nil
+	}
+	_, err := db.Exec(ctx, fmt.Sprintf(createUpgradeHistoryTable, db.UpgradeHistoryTable))
+	if err != nil {
+		return fmt.Errorf("failed to ensure upgrade history table exists: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) recordUpgrade(ctx context.Context, upg *Upgrade, from, compat int, start time.Time) error {
+	if db.UpgradeHistoryTable == "" {
+		return nil
+	}
+	checksum, err := upg.checksum(db)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum of upgrade v%d->v%d: %w", from, upg.upgradesTo, err)
+	}
+	_, err = db.Exec(ctx, fmt.Sprintf(
+		"INSERT INTO %s (from_version, to_version, compat, message, checksum, started_at, duration_ms, app_version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		db.UpgradeHistoryTable,
+	), from, upg.upgradesTo, compat, upg.message, checksum, start.UnixMilli(), time.Since(start).Milliseconds(), db.AppVersion)
+	if err != nil {
+		return fmt.Errorf("failed to record upgrade v%d->v%d in history: %w", from, upg.upgradesTo, err)
+	}
+	return nil
+}
+
+func (ut UpgradeTable) find(from, to int) *Upgrade {
+	if from < to && from < len(ut) && ut[from].fn != nil && ut[from].upgradesTo == to {
+		return &ut[from]
+	} else if from > to && to < len(ut) && ut[to].downgrade != nil && ut[to].downgrade.from == from {
+		return ut[to].downgrade
+	}
+	return nil
+}
+
+// VerifyUpgradeChecksums compares the checksums of upgrades recorded in the upgrade history table
+// to the current upgrade table, and returns the upgrades whose SQL has changed after being applied.
+//
+// Only the most recent application of each upgrade is checked. If the history table isn't enabled
+// or doesn't exist yet, this returns nothing.
+func (db *Database) VerifyUpgradeChecksums(ctx context.Context) ([]ChecksumMismatch, error) {
+	if db.UpgradeHistoryTable == "" {
+		return nil, nil
+	} else if exists, err := db.TableExists(ctx, db.UpgradeHistoryTable); err != nil {
+		return nil, fmt.Errorf("failed to check if upgrade history table exists: %w", err)
+	} else if !exists {
+		return nil, nil
+	}
+	rows, err := db.Query(ctx, fmt.Sprintf(
+		"SELECT from_version, to_version, checksum FROM %s WHERE checksum IS NOT NULL ORDER BY started_at",
+		db.UpgradeHistoryTable,
+	))
+	type versionPair struct{ from, to int }
+	type appliedUpgrade struct {
+		versionPair
+		checksum string
+	}
+	applied := make(map[versionPair]string)
+	var order []versionPair
+	err = NewRowIterWithError(rows, func(row Scannable) (item appliedUpgrade, err error) {
+		err = row.Scan(&item.from, &item.to, &item.checksum)
+		return
+	}, err).Iter(func(item appliedUpgrade) (bool, error) {
+		if _, ok := applied[item.versionPair]; !ok {
+			order = append(order, item.versionPair)
+		}
+		applied[item.versionPair] = item.checksum
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upgrade history: %w", err)
+	}
+	var mismatches []ChecksumMismatch
+	for _, pair := range order {
+		upg := db.UpgradeTable.find(pair.from, pair.to)
+		if upg == nil {
+			continue
+		}
+		current, err := upg.checksum(db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate checksum of upgrade v%d->v%d: %w", pair.from, pair.to, err)
+		} else if current.Valid && current.String != applied[pair] {
+			mismatches = append(mismatches, ChecksumMismatch{
+				From:    pair.from,
+				To:      pair.to,
+				Message: upg.message,
+				Applied: applied[pair],
+				Current: current.String,
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+func (db *Database) warnChecksumMismatches(ctx context.Context) error {
+	mismatches, err := db.VerifyUpgradeChecksums(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mismatch := range mismatches {
+		zerolog.Ctx(ctx).Warn().
+			Str("version_table", db.VersionTable).
+			Int("from", mismatch.From).
+			Int("to", mismatch.To).
+			Str("description", mismatch.Message).
+			Str("applied_checksum", mismatch.Applied).
+			Str("current_checksum", mismatch.Current).
+			Msg("Database upgrade has changed since it was applied")
+	}
+	return nil
+}
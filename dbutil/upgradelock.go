@@ -0,0 +1,202 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/util/random"
+)
+
+var ErrUpgradeLockTimeout = errors.New("timed out waiting for database upgrade lock")
+
+const (
+	upgradeLockPollInterval = 500 * time.Millisecond
+	upgradeLockLogInterval  = 5 * time.Second
+	// SQLite locks that haven't been refreshed for this long are assumed to belong to a crashed process and are taken over.
+	sqliteUpgradeLockStaleAfter = 15 * time.Minute
+	// The holder of a SQLite lock refreshes it this often, so that long upgrades don't lose the lock.
+	sqliteUpgradeLockHeartbeatInterval = time.Minute
+)
+
+// sqliteUpgradeLockTable is the table used for upgrade locks on SQLite. It's created on demand.
+const sqliteUpgradeLockTable = "dbutil_upgrade_lock"
+
+const createSQLiteUpgradeLockTable = `
+CREATE TABLE IF NOT EXISTS ` + sqliteUpgradeLockTable + ` (
+	lock_key    TEXT   PRIMARY KEY,
+	holder      TEXT   NOT NULL,
+	acquired_at BIGINT NOT NULL
+)
+`
+
+const tryLockSQLite = `
+INSERT INTO ` + sqliteUpgradeLockTable + ` (lock_key, holder, acquired_at) VALUES ($1, $2, $3)
+ON CONFLICT (lock_key) DO UPDATE
+	SET holder=excluded.holder, acquired_at=excluded.acquired_at
+	WHERE ` + sqliteUpgradeLockTable + `.acquired_at < $4
+`
+
+const refreshLockSQLite = `UPDATE ` + sqliteUpgradeLockTable + ` SET acquired_at=$3 WHERE lock_key=$1 AND holder=$2`
+
+func (db *Database) upgradeLockKey() string {
+	return db.Owner + "/" + db.VersionTable
+}
+
+func (db *Database) postgresUpgradeLockKey() int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(db.upgradeLockKey()))
+	return int64(hash.Sum64())
+}
+
+func (db *Database) prepareUpgradeLock(ctx context.Context) (tryLock func() (bool, error), unlock func(), err error) {
+	switch db.Dialect {
+	case Postgres:
+		// Advisory locks are bound to the session, so a dedicated connection is needed.
+		rawConn, err := db.RawDB.Conn(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to acquire connection for upgrade lock: %w", err)
+		}
+		conn := &LoggingExecable{UnderlyingExecable: rawConn, db: db}
+		key := db.postgresUpgradeLockKey()
+		var locked bool
+		tryLock = func() (bool, error) {
+			err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+			return locked, err
+		}
+		unlock = func() {
+			if locked {
+				_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+				if err != nil {
+					zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to release database upgrade lock")
+				}
+			}
+			_ = rawConn.Close()
+		}
+		return tryLock, unlock, nil
+	case SQLite:
+		_, err = db.Exec(ctx, createSQLiteUpgradeLockTable)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to ensure upgrade lock table exists: %w", err)
+		}
+		key := db.upgradeLockKey()
+		holder := random.String(16)
+		var stopHeartbeat func()
+		tryLock = func() (bool, error) {
+			now := time.Now()
+			res, err := db.Exec(ctx, tryLockSQLite, key, holder, now.UnixMilli(), now.Add(-sqliteUpgradeLockStaleAfter).UnixMilli())
+			if err != nil {
+				return false, err
+			}
+			affected, err := res.RowsAffected()
+			if affected > 0 && err == nil {
+				stopHeartbeat = db.startUpgradeLockHeartbeat(ctx, key, holder)
+			}
+			return affected > 0, err
+		}
+		unlock = func() {
+			if stopHeartbeat != nil {
+				stopHeartbeat()
+			}
+			_, err := db.Exec(context.WithoutCancel(ctx), "DELETE FROM "+sqliteUpgradeLockTable+" WHERE lock_key=$1 AND holder=$2", key, holder)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to release database upgrade lock")
+			}
+		}
+		return tryLock, unlock, nil
+	default:
+		return nil, nil, ErrUnsupportedDialect
+	}
+}
+
+// startUpgradeLockHeartbeat periodically refreshes the SQLite upgrade lock until the returned function is called.
+func (db *Database) startUpgradeLockHeartbeat(ctx context.Context, key, holder string) (stop func()) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(sqliteUpgradeLockHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			held, err := db.refreshUpgradeLockSQLite(ctx, key, holder)
+			if err != nil {
+				if ctx.Err() == nil {
+					zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to refresh database upgrade lock")
+				}
+			} else if !held {
+				zerolog.Ctx(ctx).Error().Msg("Database upgrade lock was taken over by another instance")
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// refreshUpgradeLockSQLite bumps the acquisition time of the SQLite upgrade lock so that it doesn't become stale.
+// It returns false if the lock is no longer held by the given holder.
+func (db *Database) refreshUpgradeLockSQLite(ctx context.Context, key, holder string) (held bool, err error) {
+	res, err := db.Exec(ctx, refreshLockSQLite, key, holder, time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// acquireUpgradeLock takes a lock that prevents other instances from upgrading the same database
+// concurrently. On Postgres, this uses an advisory lock, while SQLite uses a separate lock table.
+func (db *Database) acquireUpgradeLock(ctx context.Context) (release func(), err error) {
+	tryLock, unlock, err := db.prepareUpgradeLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log := zerolog.Ctx(ctx).With().Str("version_table", db.VersionTable).Logger()
+	start := time.Now()
+	var lastLog time.Time
+	for {
+		locked, err := tryLock()
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("failed to acquire database upgrade lock: %w", err)
+		} else if locked {
+			if !lastLog.IsZero() {
+				log.Info().
+					Float64("waited_seconds", time.Since(start).Seconds()).
+					Msg("Acquired database upgrade lock")
+			}
+			return unlock, nil
+		} else if db.UpgradeLockTimeout > 0 && time.Since(start) >= db.UpgradeLockTimeout {
+			unlock()
+			return nil, fmt.Errorf("%w after %s", ErrUpgradeLockTimeout, db.UpgradeLockTimeout)
+		} else if time.Since(lastLog) >= upgradeLockLogInterval {
+			lastLog = time.Now()
+			log.Info().
+				Float64("waited_seconds", time.Since(start).Seconds()).
+				Msg("Waiting for another instance to finish upgrading the database")
+		}
+		select {
+		case <-time.After(upgradeLockPollInterval):
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
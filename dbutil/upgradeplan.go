@@ -0,0 +1,132 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PlannedUpgrade is a single upgrade step that [Database.Upgrade] would run.
+type PlannedUpgrade struct {
+	From    int
+	To      int
+	Compat  int
+	Message string
+	TxnMode TxnMode
+	// Hooks are the names of the Go functions called after the SQL (see [UpgradeHooks]).
+	Hooks []string
+
+	// HasSQL is false for upgrades implemented in Go, which means SQL will always be empty.
+	HasSQL bool
+	// SQL is the dialect-filtered SQL that the upgrade would execute.
+	// It's empty if the upgrade is skipped for the dialect of the database.
+	SQL string
+}
+
+// UpgradePlan is the ordered list of upgrade steps returned by [Database.PlanUpgrade].
+type UpgradePlan []PlannedUpgrade
+
+// String renders the plan in the same format as upgrade files, which is useful for dry runs.
+func (plan UpgradePlan) String() string {
+	var buf strings.Builder
+	for i, upg := range plan {
+		if i > 0 {
+			buf.WriteString("\n\n")
+		}
+		_, _ = fmt.Fprintf(&buf, "-- v%d -> v%d", upg.From, upg.To)
+		if upg.Compat != upg.To {
+			_, _ = fmt.Fprintf(&buf, " (compatible with v%d+)", upg.Compat)
+		}
+		_, _ = fmt.Fprintf(&buf, ": %s\n-- transaction: %s\n", upg.Message, upg.TxnMode)
+		for _, hook := range upg.Hooks {
+			_, _ = fmt.Fprintf(&buf, "-- run: %s\n", hook)
+		}
+		if !upg.HasSQL {
+			buf.WriteString("-- (upgrade is implemented in Go)")
+		} else if upg.SQL == "" {
+			buf.WriteString("-- (upgrade is skipped for this database dialect)")
+		} else {
+			buf.WriteString(strings.TrimSpace(upg.SQL))
+		}
+	}
+	return buf.String()
+}
+
+func (db *Database) peekDatabaseOwner(ctx context.Context) error {
+	if err := db.checkForeignTables(ctx); err != nil {
+		return err
+	} else if db.Owner == "" {
+		return nil
+	} else if exists, err := db.TableExists(ctx, "database_owner"); err != nil {
+		return fmt.Errorf("failed to check if database owner table exists: %w", err)
+	} else if !exists {
+		return nil
+	}
+	var owner string
+	err := db.QueryRow(ctx, "SELECT owner FROM database_owner WHERE key=0").Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check database owner: %w", err)
+	} else if owner != db.Owner {
+		return NotOwnedError{owner}
+	}
+	return nil
+}
+
+// PlanUpgrade returns the list of upgrades that [Database.Upgrade] would run, including the SQL
+// that would be executed for upgrades defined in SQL files. It can be used as a dry run, as it
+// only reads the database without creating or modifying any tables.
+func (db *Database) PlanUpgrade(ctx context.Context) (UpgradePlan, error) {
+	err := db.peekDatabaseOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	version, compat, err := db.peekVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if compat > len(db.UpgradeTable) {
+		if db.IgnoreUnsupportedDatabase {
+			return UpgradePlan{}, nil
+		}
+		return nil, fmt.Errorf("%w: currently on v%d (compatible down to v%d), latest known: v%d", ErrUnsupportedDatabaseVersion, version, compat, len(db.UpgradeTable))
+	}
+
+	plan := UpgradePlan{}
+	for version < len(db.UpgradeTable) {
+		upgradeItem := db.UpgradeTable[version]
+		if upgradeItem.fn == nil {
+			version++
+			continue
+		}
+		planned := PlannedUpgrade{
+			From:    version,
+			To:      upgradeItem.upgradesTo,
+			Compat:  upgradeItem.compatVersion,
+			Message: upgradeItem.message,
+			TxnMode: upgradeItem.transaction,
+			Hooks:   upgradeItem.hooks,
+		}
+		if upgradeItem.sql != nil {
+			planned.HasSQL = true
+			planned.SQL, _, err = upgradeItem.sql(db)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render upgrade v%d->v%d: %w", version, upgradeItem.upgradesTo, err)
+			}
+		}
+		plan = append(plan, planned)
+		version = upgradeItem.upgradesTo
+	}
+	return plan, nil
+}
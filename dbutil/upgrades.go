@@ -11,6 +11,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 )
 
 type upgradeFunc func(context.Context, *Database) error
@@ -18,11 +19,20 @@ type upgradeFunc func(context.Context, *Database) error
 type Upgrade struct {
 	message string
 	fn      upgradeFunc
+	sql     sqlRenderFunc
+	hooks   []string
+	// fileName is the name of the file the upgrade was parsed from, used in validation errors.
+	fileName string
+	// split is true for upgrades that have separate .postgres.sql and .sqlite.sql files.
+	split bool
 
 	from          int
 	upgradesTo    int
 	compatVersion int
 	transaction   TxnMode
+
+	// downgrade is an optional downgrade from some newer version back to this upgrade's source version.
+	downgrade *Upgrade
 }
 
 func (u *Upgrade) DangerouslyRun(ctx context.Context, db *Database) (upgradesTo, compat int, err error) {
@@ -33,6 +43,7 @@ var ErrUnsupportedDatabaseVersion = errors.New("unsupported database schema vers
 var ErrForeignTables = errors.New("the database contains foreign tables")
 var ErrNotOwned = errors.New("the database is owned by")
 var ErrUnsupportedDialect = errors.New("unsupported database dialect")
+var ErrNoDowngradePath = errors.New("no downgrade path found")
 
 type NotOwnedError struct {
 	Owner string
@@ -75,9 +86,29 @@ func (db *Database) getVersion(ctx context.Context) (version, compat int, err er
 	if err = db.upgradeVersionTable(ctx); err != nil {
 		return
 	}
+	return db.readVersion(ctx, "compat")
+}
+
+// peekVersion is like getVersion, but it doesn't create or modify the version table.
+func (db *Database) peekVersion(ctx context.Context) (version, compat int, err error) {
+	var exists bool
+	if exists, err = db.TableExists(ctx, db.VersionTable); err != nil {
+		err = fmt.Errorf("failed to check if version table exists: %w", err)
+		return
+	} else if !exists {
+		return
+	} else if exists, err = db.ColumnExists(ctx, db.VersionTable, "compat"); err != nil {
+		err = fmt.Errorf("failed to check if version table is up to date: %w", err)
+		return
+	} else if !exists {
+		return db.readVersion(ctx, "NULL")
+	}
+	return db.readVersion(ctx, "compat")
+}
 
+func (db *Database) readVersion(ctx context.Context, compatColumn string) (version, compat int, err error) {
 	var compatNull sql.NullInt32
-	err = db.QueryRow(ctx, fmt.Sprintf("SELECT version, compat FROM %s LIMIT 1", db.VersionTable)).Scan(&version, &compatNull)
+	err = db.QueryRow(ctx, fmt.Sprintf("SELECT version, %s FROM %s LIMIT 1", compatColumn, db.VersionTable)).Scan(&version, &compatNull)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = nil
 	}
@@ -130,18 +161,26 @@ CREATE TABLE IF NOT EXISTS database_owner (
 )
 `
 
+func (db *Database) checkForeignTables(ctx context.Context) error {
+	if db.IgnoreForeignTables {
+		return nil
+	}
+	if exists, err := db.TableExists(ctx, "state_groups_state"); err != nil {
+		return fmt.Errorf("failed to check if state_groups_state exists: %w", err)
+	} else if exists {
+		return fmt.Errorf("%w (found state_groups_state, likely belonging to Synapse)", ErrForeignTables)
+	} else if exists, err = db.TableExists(ctx, "roomserver_rooms"); err != nil {
+		return fmt.Errorf("failed to check if roomserver_rooms exists: %w", err)
+	} else if exists {
+		return fmt.Errorf("%w (found roomserver_rooms, likely belonging to Dendrite)", ErrForeignTables)
+	}
+	return nil
+}
+
 func (db *Database) checkDatabaseOwner(ctx context.Context) error {
 	var owner string
-	if !db.IgnoreForeignTables {
-		if exists, err := db.TableExists(ctx, "state_groups_state"); err != nil {
-			return fmt.Errorf("failed to check if state_groups_state exists: %w", err)
-		} else if exists {
-			return fmt.Errorf("%w (found state_groups_state, likely belonging to Synapse)", ErrForeignTables)
-		} else if exists, err = db.TableExists(ctx, "roomserver_rooms"); err != nil {
-			return fmt.Errorf("failed to check if roomserver_rooms exists: %w", err)
-		} else if exists {
-			return fmt.Errorf("%w (found roomserver_rooms, likely belonging to Dendrite)", ErrForeignTables)
-		}
+	if err := db.checkForeignTables(ctx); err != nil {
+		return err
 	}
 	if db.Owner == "" {
 		return nil
@@ -202,6 +241,13 @@ func (db *Database) DoSQLiteTransactionWithoutForeignKeys(ctx context.Context, d
 }
 
 func (db *Database) Upgrade(ctx context.Context) error {
+	if db.LockUpgrades {
+		release, err := db.acquireUpgradeLock(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 	err := db.checkDatabaseOwner(ctx)
 	if err != nil {
 		return err
@@ -220,7 +266,18 @@ func (db *Database) Upgrade(ctx context.Context) error {
 		return fmt.Errorf("%w: currently on v%d (compatible down to v%d), latest known: v%d", ErrUnsupportedDatabaseVersion, version, compat, len(db.UpgradeTable))
 	}
 
+	if db.UpgradeHistoryTable != "" {
+		if err = db.ensureUpgradeHistoryTable(ctx); err != nil {
+			return err
+		} else if err = db.warnChecksumMismatches(ctx); err != nil {
+			return err
+		}
+	}
+
 	db.Log.PrepareUpgrade(version, compat, len(db.UpgradeTable))
+	if version < len(db.UpgradeTable) {
+		defer db.ClearStatementCache()
+	}
 	logVersion := version
 	for version < len(db.UpgradeTable) {
 		upgradeItem := db.UpgradeTable[version]
@@ -229,35 +286,131 @@ func (db *Database) Upgrade(ctx context.Context) error {
 			continue
 		}
 		doUpgrade := func(ctx context.Context) error {
+			start := time.Now()
 			err = upgradeItem.fn(ctx, db)
 			if err != nil {
 				return fmt.Errorf("failed to run upgrade v%d->v%d: %w", version, upgradeItem.upgradesTo, err)
 			}
-			version = upgradeItem.upgradesTo
-			logVersion = version
-			err = db.setVersion(ctx, version, upgradeItem.compatVersion)
+			err = db.setVersion(ctx, upgradeItem.upgradesTo, upgradeItem.compatVersion)
+			if err != nil {
+				return err
+			}
+			err = db.recordUpgrade(ctx, &upgradeItem, version, upgradeItem.compatVersion, start)
 			if err != nil {
 				return err
 			}
+			version = upgradeItem.upgradesTo
+			logVersion = version
 			return nil
 		}
 		db.Log.DoUpgrade(logVersion, upgradeItem.upgradesTo, upgradeItem.message, upgradeItem.transaction)
-		switch upgradeItem.transaction {
-		case TxnModeOff:
-			err = doUpgrade(ctx)
-		case TxnModeOn:
-			err = db.DoTxn(ctx, nil, doUpgrade)
-		case TxnModeSQLiteForeignKeysOff:
-			switch db.Dialect {
-			case SQLite:
-				err = db.DoSQLiteTransactionWithoutForeignKeys(ctx, doUpgrade)
-			default:
-				err = db.DoTxn(ctx, nil, doUpgrade)
-			}
+		err = db.doWithTxnMode(ctx, upgradeItem.transaction, doUpgrade)
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+func (db *Database) doWithTxnMode(ctx context.Context, txn TxnMode, fn func(context.Context) error) error {
+	switch txn {
+	case TxnModeOff:
+		return fn(ctx)
+	case TxnModeOn:
+		return db.DoTxn(ctx, nil, fn)
+	case TxnModeSQLiteForeignKeysOff:
+		switch db.Dialect {
+		case SQLite:
+			return db.DoSQLiteTransactionWithoutForeignKeys(ctx, fn)
+		default:
+			return db.DoTxn(ctx, nil, fn)
+		}
+	default:
+		return fmt.Errorf("unknown transaction mode %q", txn)
+	}
+}
+
+// findDowngrade finds the downgrade from the given version that goes back as far as possible
+// without going past the target version.
+func (ut UpgradeTable) findDowngrade(from, target int) *Upgrade {
+	for to := max(target, 0); to < from && to < len(ut); to++ {
+		if dg := ut[to].downgrade; dg != nil && dg.from == from {
+			return dg
+		}
+	}
+	return nil
+}
+
+// compatVersionOf returns the compatibility version that was stored when upgrading to the given version.
+func (ut UpgradeTable) compatVersionOf(version int) int {
+	for _, upg := range ut {
+		if upg.fn != nil && upg.upgradesTo == version {
+			return upg.compatVersion
+		}
+	}
+	return version
+}
+
+// Downgrade reverts the database schema to the given version using the downgrades in the upgrade table.
+//
+// The downgrades are applied one by one starting from the current version. If a downgrade from
+// the current version can't be found before reaching the target version, an error wrapping
+// [ErrNoDowngradePath] is returned. The compatibility version stored for each step is the one
+// specified in the downgrade header, or the one the corresponding upgrade would have stored.
+func (db *Database) Downgrade(ctx context.Context, targetVersion int) error {
+	if targetVersion < 0 {
+		return fmt.Errorf("invalid downgrade target version v%d", targetVersion)
+	}
+	if db.LockUpgrades {
+		release, err := db.acquireUpgradeLock(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	err := db.checkDatabaseOwner(ctx)
+	if err != nil {
+		return err
+	}
+
+	version, _, err := db.getVersion(ctx)
+	if err != nil {
+		return err
+	} else if version < targetVersion {
+		return fmt.Errorf("can't downgrade to v%d: database is already at v%d", targetVersion, version)
+	}
+
+	if err = db.ensureUpgradeHistoryTable(ctx); err != nil {
+		return err
+	}
+
+	defer db.ClearStatementCache()
+	for version > targetVersion {
+		downgradeItem := db.UpgradeTable.findDowngrade(version, targetVersion)
+		if downgradeItem == nil {
+			return fmt.Errorf("%w from v%d towards v%d", ErrNoDowngradePath, version, targetVersion)
+		}
+		compat := downgradeItem.compatVersion
+		if compat <= 0 {
+			compat = db.UpgradeTable.compatVersionOf(downgradeItem.upgradesTo)
+		}
+		db.Log.DoUpgrade(version, downgradeItem.upgradesTo, downgradeItem.message, downgradeItem.transaction)
+		err = db.doWithTxnMode(ctx, downgradeItem.transaction, func(ctx context.Context) error {
+			start := time.Now()
+			err := downgradeItem.fn(ctx, db)
+			if err != nil {
+				return fmt.Errorf("failed to run downgrade v%d->v%d: %w", version, downgradeItem.upgradesTo, err)
+			}
+			err = db.setVersion(ctx, downgradeItem.upgradesTo, compat)
+			if err != nil {
+				return err
+			}
+			return db.recordUpgrade(ctx, downgradeItem, version, compat, start)
+		})
 		if err != nil {
 			return err
 		}
+		version = downgradeItem.upgradesTo
 	}
 	return nil
 }
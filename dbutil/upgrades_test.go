@@ -12,8 +12,11 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -131,6 +134,88 @@ func testCompatCheck(dialect Dialect) func(t *testing.T) {
 	}
 }
 
+func testDowngrade(dialect Dialect) func(t *testing.T) {
+	return func(t *testing.T) {
+		conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+
+		db := &Database{
+			RawDB:        conn,
+			Log:          NoopLogger,
+			VersionTable: "version",
+			Dialect:      dialect,
+			UpgradeTable: makeTable(),
+			txnCtxKey:    contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+
+			IgnoreForeignTables: true,
+		}
+		db.LoggingDB.UnderlyingExecable = conn
+		db.LoggingDB.db = db
+
+		expectVersionCheck(db.Dialect, mock, 5, 3)
+		mock.ExpectBegin()
+		mock.ExpectExec("\nDELETE FROM foo WHERE key='meow 2';\n").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectVersionBump(db.Dialect, mock, 4, 4)
+		mock.ExpectCommit()
+		err = db.Downgrade(context.TODO(), 4)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		expectVersionCheck(db.Dialect, mock, 4, 4)
+		err = db.Downgrade(context.TODO(), 0)
+		require.ErrorIs(t, err, ErrNoDowngradePath)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func testPlanUpgrade(dialect Dialect) func(t *testing.T) {
+	return func(t *testing.T) {
+		conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+
+		expectedUpgrade1, err := rawUpgrades.ReadFile(fmt.Sprintf("samples/output/01-%s.sql", dialect.String()))
+		require.NoError(t, err)
+		expectedUpgrade2, err := rawUpgrades.ReadFile(fmt.Sprintf("samples/output/04-%s.sql", dialect.String()))
+		require.NoError(t, err)
+		expectedUpgrade3, err := rawUpgrades.ReadFile(fmt.Sprintf("samples/output/05-%s.sql", dialect.String()))
+		require.NoError(t, err)
+
+		db := &Database{
+			RawDB:        conn,
+			Log:          NoopLogger,
+			VersionTable: "version",
+			Dialect:      dialect,
+			UpgradeTable: makeTable(),
+			txnCtxKey:    contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+
+			IgnoreForeignTables: true,
+		}
+		db.LoggingDB.UnderlyingExecable = conn
+		db.LoggingDB.db = db
+
+		if dialect == Postgres {
+			mock.ExpectQuery(tableExistsPostgres).
+				WithArgs("version").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		} else {
+			mock.ExpectQuery(tableExistsSQLite).
+				WithArgs("version").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		}
+		expectVersionCheck(db.Dialect, mock, 0, 0)
+		plan, err := db.PlanUpgrade(context.TODO())
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, UpgradePlan{
+			{From: 0, To: 3, Compat: 3, Message: "Sample revision jump", TxnMode: TxnModeOn, HasSQL: true, SQL: string(expectedUpgrade1)},
+			{From: 3, To: 4, Compat: 4, Message: "Sample outside transaction", TxnMode: TxnModeOff, HasSQL: true, SQL: "\n" + string(expectedUpgrade2)},
+			{From: 4, To: 5, Compat: 3, Message: "Sample backwards-compatible upgrade", TxnMode: TxnModeOn, HasSQL: true, SQL: "\n" + string(expectedUpgrade3)},
+		}, plan)
+		require.True(t, strings.HasPrefix(plan.String(), "-- v0 -> v3: Sample revision jump\n-- transaction: on\nCREATE TABLE foo ("))
+	}
+}
+
 func TestDatabase_Upgrade(t *testing.T) {
 	t.Run("SQLite", testUpgrade(SQLite))
 	t.Run("Postgres", testUpgrade(Postgres))
@@ -140,3 +225,133 @@ func TestDatabase_Upgrade_CompatCheck(t *testing.T) {
 	t.Run("SQLite", testCompatCheck(SQLite))
 	t.Run("Postgres", testCompatCheck(Postgres))
 }
+
+func TestDatabase_Downgrade(t *testing.T) {
+	t.Run("SQLite", testDowngrade(SQLite))
+	t.Run("Postgres", testDowngrade(Postgres))
+}
+
+func TestDatabase_PlanUpgrade(t *testing.T) {
+	t.Run("SQLite", testPlanUpgrade(SQLite))
+	t.Run("Postgres", testPlanUpgrade(Postgres))
+}
+
+func TestDatabase_UpgradeHistory(t *testing.T) {
+	upgrades := fstest.MapFS{
+		"01-init.sql":  {Data: []byte("-- v1: Initial revision\nCREATE TABLE foo (id INTEGER PRIMARY KEY, data TEXT);\n")},
+		"02-index.sql": {Data: []byte("-- v2: Add index\nCREATE INDEX foo_data_idx ON foo (data);\n")},
+	}
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.UpgradeHistoryTable = "version_history"
+	db.AppVersion = "v1.2.3"
+	db.UpgradeTable = BuildUpgradeTable().WithFS(upgrades).Finish()
+	ctx := context.Background()
+	require.NoError(t, db.Upgrade(ctx))
+
+	var count int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM version_history WHERE app_version='v1.2.3' AND checksum IS NOT NULL").Scan(&count))
+	require.Equal(t, 2, count)
+	mismatches, err := db.VerifyUpgradeChecksums(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+
+	upgrades["02-index.sql"] = &fstest.MapFile{Data: []byte("-- v2: Add index\nCREATE UNIQUE INDEX foo_data_idx ON foo (data);\n")}
+	db.UpgradeTable = BuildUpgradeTable().WithFS(upgrades).Finish()
+	mismatches, err = db.VerifyUpgradeChecksums(ctx)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	require.Equal(t, 1, mismatches[0].From)
+	require.Equal(t, 2, mismatches[0].To)
+	require.NotEqual(t, mismatches[0].Applied, mismatches[0].Current)
+}
+
+func TestDatabase_UpgradeLock(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.Owner = "test"
+	db.UpgradeLockTimeout = 100 * time.Millisecond
+	ctx := context.Background()
+
+	release, err := db.acquireUpgradeLock(ctx)
+	require.NoError(t, err)
+	_, err = db.acquireUpgradeLock(ctx)
+	require.ErrorIs(t, err, ErrUpgradeLockTimeout)
+	childRelease, err := db.Child("child_version", nil, nil).acquireUpgradeLock(ctx)
+	require.NoError(t, err)
+	childRelease()
+	release()
+	release, err = db.acquireUpgradeLock(ctx)
+	require.NoError(t, err)
+	release()
+}
+
+func TestDatabase_UpgradeLock_Heartbeat(t *testing.T) {
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	release, err := db.acquireUpgradeLock(ctx)
+	require.NoError(t, err)
+	defer release()
+	key := db.upgradeLockKey()
+	var holder string
+	require.NoError(t, db.QueryRow(ctx, "SELECT holder FROM dbutil_upgrade_lock WHERE lock_key=$1", key).Scan(&holder))
+	// Pretend the upgrade has been running for longer than the stale timeout
+	_, err = db.Exec(ctx, "UPDATE dbutil_upgrade_lock SET acquired_at=$1", time.Now().Add(-2*sqliteUpgradeLockStaleAfter).UnixMilli())
+	require.NoError(t, err)
+	// A heartbeat makes the lock fresh again, so other instances can't take it over
+	held, err := db.refreshUpgradeLockSQLite(ctx, key, holder)
+	require.NoError(t, err)
+	assert.True(t, held)
+	tryLock, unlock, err := db.prepareUpgradeLock(ctx)
+	require.NoError(t, err)
+	locked, err := tryLock()
+	require.NoError(t, err)
+	assert.False(t, locked)
+	unlock()
+
+	held, err = db.refreshUpgradeLockSQLite(ctx, key, "someone else")
+	require.NoError(t, err)
+	assert.False(t, held)
+}
+
+func TestDatabase_UpgradeLock_Postgres(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	db := &Database{
+		RawDB:        conn,
+		Log:          NoopLogger,
+		VersionTable: "version",
+		Owner:        "test",
+		Dialect:      Postgres,
+		txnCtxKey:    contextKey(nextContextKeyDatabaseTransaction.Add(1)),
+	}
+	db.LoggingDB.UnderlyingExecable = conn
+	db.LoggingDB.db = db
+	key := db.postgresUpgradeLockKey()
+	ctx := context.Background()
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock($1)").WithArgs(key).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	release, err := db.acquireUpgradeLock(ctx)
+	require.NoError(t, err)
+	release()
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	db.UpgradeLockTimeout = 100 * time.Millisecond
+	for range 2 {
+		mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WithArgs(key).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	}
+	_, err = db.acquireUpgradeLock(ctx)
+	require.ErrorIs(t, err, ErrUpgradeLockTimeout)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
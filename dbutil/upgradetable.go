@@ -66,10 +66,33 @@ func (ut WIPUpgradeTable) With(upg Upgrade) WIPUpgradeTable {
 	} else if (ut)[from].fn != nil {
 		panic(fmt.Errorf("dbutil: tried to override upgrade at %d (%q) with %q", from, ut[from].message, upg.message))
 	}
+	upg.downgrade = ut[from].downgrade
 	ut[from] = upg
 	return ut
 }
 
+// WithRawDowngrade adds a downgrade from the newer version `from` back to the older version `to`.
+//
+// If compat is zero, the compatibility version that the upgrade to the target version would
+// store is used after downgrading.
+func (ut WIPUpgradeTable) WithRawDowngrade(from, to, compat int, message string, txn TxnMode, fn upgradeFunc) WIPUpgradeTable {
+	return ut.WithDowngrade(Upgrade{message: message, fn: fn, from: from, upgradesTo: to, compatVersion: compat, transaction: txn})
+}
+
+// WithDowngrade adds a downgrade to the table. Only one downgrade can target each version.
+func (ut WIPUpgradeTable) WithDowngrade(dg Upgrade) WIPUpgradeTable {
+	to := dg.upgradesTo
+	if to < 0 || dg.from <= to {
+		panic(fmt.Errorf("dbutil: invalid downgrade from v%d to v%d (%q)", dg.from, to, dg.message))
+	} else if len(ut) <= to {
+		ut = slices.Grow(ut, to+1)[:to+1]
+	} else if ut[to].downgrade != nil {
+		panic(fmt.Errorf("dbutil: tried to override downgrade to %d (%q) with %q", to, ut[to].downgrade.message, dg.message))
+	}
+	ut[to].downgrade = &dg
+	return ut
+}
+
 var upgradeHeaderRegex = regexp.MustCompile(`^-- (?:v(\d+) -> )?v(\d+)(?: \(compatible with v(\d+)\+\))?: (.+)$`)
 
 var transactionDisableRegex = regexp.MustCompile(`^-- transaction: ([a-z-]*)`)
@@ -117,6 +140,21 @@ func parseFileHeader(file []byte) (from, to, compat int, message string, txn Txn
 	return
 }
 
+var runHookRegex = regexp.MustCompile(`^-- run: ([A-Za-z0-9_.-]+)\s*$`)
+
+// parseHookFlags parses `-- run: name` lines at the beginning of the given lines.
+func parseHookFlags(lines [][]byte) (hooks []string, remainingLines [][]byte) {
+	for len(lines) > 0 {
+		match := runHookRegex.FindSubmatch(lines[0])
+		if match == nil {
+			break
+		}
+		hooks = append(hooks, string(match[1]))
+		lines = lines[1:]
+	}
+	return hooks, lines
+}
+
 var dialectLineFilter = regexp.MustCompile(`^\s*-- only: (postgres|sqlite)(?: for next (\d+) lines| until "(end) only")?(?: \(lines? (commented)\))?`)
 
 // Constants used to make parseDialectFilter clearer
@@ -195,6 +233,8 @@ func (db *Database) filterSQLUpgrade(lines [][]byte) (string, error) {
 			if i == len(lines) {
 				return "", fmt.Errorf(`didn't get end tag matching start %q at line %d`, string(startedAtMatch[1]), startedAt)
 			}
+		} else if i+lineCount >= len(lines) {
+			return "", fmt.Errorf("dialect filter at line %d covers %d lines, but there are only %d lines after it", i+1, lineCount, len(lines)-i-1)
 		} else if dialect != db.Dialect {
 			i += lineCount
 		} else {
@@ -215,34 +255,125 @@ func (db *Database) filterSQLUpgrade(lines [][]byte) (string, error) {
 	return string(bytes.Join(output, []byte("\n"))), nil
 }
 
-func sqlUpgradeFunc(fileName string, lines [][]byte) upgradeFunc {
+// sqlRenderFunc returns the SQL that an upgrade would execute on the given database.
+// If skip is true, the upgrade doesn't apply to the database's dialect at all.
+type sqlRenderFunc func(db *Database) (query string, skip bool, err error)
+
+func sqlUpgradeRenderFunc(lines [][]byte) sqlRenderFunc {
+	return func(db *Database) (string, bool, error) {
+		if len(lines) == 0 {
+			return "", false, nil
+		} else if dialect, skip, _, err := db.parseDialectFilter(lines[0]); err == nil && skip == skipNextLine && dialect != db.Dialect {
+			return "", true, nil
+		}
+		upgradeSQL, err := db.filterSQLUpgrade(lines)
+		return upgradeSQL, false, err
+	}
+}
+
+// UpgradeHooks is a registry of Go functions that SQL upgrade files can call using `-- run: name` lines.
+type UpgradeHooks map[string]func(ctx context.Context, db *Database) error
+
+type namedHook struct {
+	name string
+	fn   upgradeFunc
+}
+
+type hookResolver struct {
+	hooks UpgradeHooks
+	used  map[string]struct{}
+}
+
+func (hr *hookResolver) resolve(fileName string, names []string) []namedHook {
+	resolved := make([]namedHook, len(names))
+	for i, name := range names {
+		fn, ok := hr.hooks[name]
+		if !ok {
+			panic(fmt.Errorf("dbutil: upgrade %s references unknown hook %q", fileName, name))
+		}
+		hr.used[name] = struct{}{}
+		resolved[i] = namedHook{name: name, fn: fn}
+	}
+	return resolved
+}
+
+func (hr *hookResolver) checkUnused() {
+	var unused []string
+	for name := range hr.hooks {
+		if _, ok := hr.used[name]; !ok {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		slices.Sort(unused)
+		panic(fmt.Errorf("dbutil: upgrade hooks %q aren't referenced by any upgrade file", unused))
+	}
+}
+
+func runHooks(ctx context.Context, db *Database, hooks []namedHook) error {
+	for _, hook := range hooks {
+		if err := hook.fn(ctx, db); err != nil {
+			return fmt.Errorf("hook %s failed: %w", hook.name, err)
+		}
+	}
+	return nil
+}
+
+func sqlUpgradeFunc(fileName string, render sqlRenderFunc, hooks []namedHook) upgradeFunc {
 	return func(ctx context.Context, db *Database) error {
-		if dialect, skip, _, err := db.parseDialectFilter(lines[0]); err == nil && skip == skipNextLine && dialect != db.Dialect {
-			return nil
-		} else if upgradeSQL, err := db.filterSQLUpgrade(lines); err != nil {
+		upgradeSQL, skip, err := render(db)
+		if err != nil {
 			panic(fmt.Errorf("failed to parse upgrade %s: %w", fileName, err))
-		} else {
-			_, err = db.Exec(ctx, upgradeSQL)
+		} else if skip {
+			return nil
+		}
+		if err = execUpgradeSQL(ctx, db, upgradeSQL); err != nil {
 			return err
 		}
+		return runHooks(ctx, db, hooks)
 	}
 }
 
-func splitSQLUpgradeFunc(sqliteData, postgresData string) upgradeFunc {
-	return func(ctx context.Context, db *Database) (err error) {
+// execUpgradeSQL executes the given upgrade SQL, unless it only contains
+// comments and whitespace (e.g. in files that only call hooks).
+func execUpgradeSQL(ctx context.Context, db *Database, upgradeSQL string) error {
+	for line := range strings.Lines(upgradeSQL) {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "--") {
+			_, err := db.Exec(ctx, upgradeSQL)
+			return err
+		}
+	}
+	return nil
+}
+
+func splitSQLUpgradeRenderFunc(sqliteData, postgresData string) sqlRenderFunc {
+	return func(db *Database) (string, bool, error) {
 		switch db.Dialect {
 		case SQLite:
-			_, err = db.Exec(ctx, sqliteData)
+			return sqliteData, false, nil
 		case Postgres:
-			_, err = db.Exec(ctx, postgresData)
+			return postgresData, false, nil
 		default:
-			err = fmt.Errorf("unknown dialect %s", db.Dialect)
+			return "", false, fmt.Errorf("unknown dialect %s", db.Dialect)
 		}
-		return
 	}
 }
 
-func parseSplitSQLUpgrade(name string, fs fullFS, skipNames map[string]struct{}) (from, to, compat int, message string, txn TxnMode, fn upgradeFunc) {
+func splitSQLUpgradeFunc(render sqlRenderFunc, hooks []namedHook) upgradeFunc {
+	return func(ctx context.Context, db *Database) error {
+		upgradeSQL, _, err := render(db)
+		if err != nil {
+			return err
+		}
+		if err = execUpgradeSQL(ctx, db, upgradeSQL); err != nil {
+			return err
+		}
+		return runHooks(ctx, db, hooks)
+	}
+}
+
+func parseSplitSQLUpgrade(name string, fs fullFS, skipNames map[string]struct{}, hr *hookResolver) Upgrade {
 	postgresName := fmt.Sprintf("%s.postgres.sql", name)
 	sqliteName := fmt.Sprintf("%s.sqlite.sql", name)
 	skipNames[postgresName] = struct{}{}
@@ -255,23 +386,33 @@ func parseSplitSQLUpgrade(name string, fs fullFS, skipNames map[string]struct{})
 	if err != nil {
 		panic(err)
 	}
-	from, to, compat, message, txn, _, err = parseFileHeader(postgresData)
+	from, to, compat, message, txn, postgresLines, err := parseFileHeader(postgresData)
 	if err != nil {
 		panic(fmt.Errorf("failed to parse header in %s: %w", postgresName, err))
 	}
-	sqliteFrom, sqliteTo, sqliteCompat, sqliteMessage, sqliteTxn, _, err := parseFileHeader(sqliteData)
+	sqliteFrom, sqliteTo, sqliteCompat, sqliteMessage, sqliteTxn, sqliteLines, err := parseFileHeader(sqliteData)
 	if err != nil {
 		panic(fmt.Errorf("failed to parse header in %s: %w", sqliteName, err))
 	}
+	postgresHooks, _ := parseHookFlags(postgresLines)
+	sqliteHooks, _ := parseHookFlags(sqliteLines)
 	if from != sqliteFrom || to != sqliteTo || compat != sqliteCompat {
 		panic(fmt.Errorf("mismatching versions in postgres and sqlite versions of %s: %d/%d -> %d/%d", name, from, sqliteFrom, to, sqliteTo))
 	} else if message != sqliteMessage {
 		panic(fmt.Errorf("mismatching message in postgres and sqlite versions of %s: %q != %q", name, message, sqliteMessage))
 	} else if txn != sqliteTxn {
 		panic(fmt.Errorf("mismatching transaction flag in postgres and sqlite versions of %s: %s != %s", name, txn, sqliteTxn))
+	} else if !slices.Equal(postgresHooks, sqliteHooks) {
+		panic(fmt.Errorf("mismatching hooks in postgres and sqlite versions of %s: %q != %q", name, postgresHooks, sqliteHooks))
 	}
-	fn = splitSQLUpgradeFunc(string(sqliteData), string(postgresData))
-	return
+	hooks := hr.resolve(name, postgresHooks)
+	render := splitSQLUpgradeRenderFunc(string(sqliteData), string(postgresData))
+	upg := WrapUpgrade(from, to, compat, message, txn, splitSQLUpgradeFunc(render, hooks))
+	upg.sql = render
+	upg.hooks = postgresHooks
+	upg.fileName = name
+	upg.split = true
+	return upg
 }
 
 type fullFS interface {
@@ -281,31 +422,84 @@ type fullFS interface {
 
 var splitFileNameRegex = regexp.MustCompile(`^(.+)\.(postgres|sqlite)\.sql$`)
 
+const downgradeFileSuffix = ".down.sql"
+
 func (ut WIPUpgradeTable) WithFS(fs fullFS) WIPUpgradeTable {
 	return ut.WithFSPath(fs, ".")
 }
 
 func (ut WIPUpgradeTable) WithFSPath(fs fullFS, dir string) WIPUpgradeTable {
+	return ut.WithFSPathAndHooks(fs, dir, nil)
+}
+
+// WithFSAndHooks is a form of WithFS that allows upgrade files to call the given Go functions.
+func (ut WIPUpgradeTable) WithFSAndHooks(fs fullFS, hooks UpgradeHooks) WIPUpgradeTable {
+	return ut.WithFSPathAndHooks(fs, ".", hooks)
+}
+
+// WithFSPathAndHooks is a form of WithFSPath that allows upgrade files to call the given Go functions.
+//
+// Upgrade files can reference hooks with `-- run: name` lines right after the header (and the
+// transaction flag, if present). The hooks are called after the SQL in the file is executed,
+// in the same transaction. This function will panic if a file references a hook that doesn't
+// exist, or if any of the given hooks aren't referenced by any file.
+func (ut WIPUpgradeTable) WithFSPathAndHooks(fs fullFS, dir string, hooks UpgradeHooks) WIPUpgradeTable {
 	files, err := fs.ReadDir(dir)
 	if err != nil {
 		panic(err)
 	}
+	hr := &hookResolver{hooks: hooks, used: make(map[string]struct{})}
 	skipNames := map[string]struct{}{}
 	for _, file := range files {
 		if file.IsDir() || !strings.HasSuffix(file.Name(), ".sql") {
 			// do nothing
 		} else if _, skip := skipNames[file.Name()]; skip {
 			// also do nothing
+		} else if strings.HasSuffix(file.Name(), downgradeFileSuffix) {
+			ut = ut.withDowngradeFile(fs, dir, file.Name(), hr)
 		} else if splitName := splitFileNameRegex.FindStringSubmatch(file.Name()); splitName != nil {
-			from, to, compat, message, txn, fn := parseSplitSQLUpgrade(splitName[1], fs, skipNames)
-			ut = ut.With(WrapUpgrade(from, to, compat, message, txn, fn))
+			ut = ut.With(parseSplitSQLUpgrade(splitName[1], fs, skipNames, hr))
 		} else if data, err := fs.ReadFile(filepath.Join(dir, file.Name())); err != nil {
 			panic(err)
 		} else if from, to, compat, message, txn, lines, err := parseFileHeader(data); err != nil {
 			panic(fmt.Errorf("dbutil: failed to parse header in %s: %w", file.Name(), err))
 		} else {
-			ut = ut.With(WrapUpgrade(from, to, compat, message, txn, sqlUpgradeFunc(file.Name(), lines)))
+			hookRefs, lines := parseHookFlags(lines)
+			hooks := hr.resolve(file.Name(), hookRefs)
+			render := sqlUpgradeRenderFunc(lines)
+			upg := WrapUpgrade(from, to, compat, message, txn, sqlUpgradeFunc(file.Name(), render, hooks))
+			upg.sql = render
+			upg.hooks = hookRefs
+			upg.fileName = file.Name()
+			ut = ut.With(upg)
 		}
 	}
+	hr.checkUnused()
 	return ut
 }
+
+func (ut WIPUpgradeTable) withDowngradeFile(fs fullFS, dir, name string, hr *hookResolver) WIPUpgradeTable {
+	data, err := fs.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		panic(err)
+	}
+	from, to, compat, message, txn, lines, err := parseFileHeader(data)
+	if err != nil {
+		panic(fmt.Errorf("dbutil: failed to parse header in %s: %w", name, err))
+	} else if from < 0 {
+		panic(fmt.Errorf("dbutil: downgrade header in %s must specify the source version", name))
+	}
+	hookRefs, lines := parseHookFlags(lines)
+	render := sqlUpgradeRenderFunc(lines)
+	return ut.WithDowngrade(Upgrade{
+		message:       message,
+		fn:            sqlUpgradeFunc(name, render, hr.resolve(name, hookRefs)),
+		sql:           render,
+		hooks:         hookRefs,
+		fileName:      name,
+		from:          from,
+		upgradesTo:    to,
+		compatVersion: compat,
+		transaction:   txn,
+	})
+}
@@ -8,9 +8,12 @@ package dbutil
 
 import (
 	"bytes"
+	"context"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type dialectFilterTest struct {
@@ -106,3 +109,62 @@ func TestFilterSQLUpgrade(t *testing.T) {
 		})
 	}
 }
+
+func TestWIPUpgradeTable_WithFSAndHooks(t *testing.T) {
+	upgrades := fstest.MapFS{
+		"01-init.sql": {Data: []byte("-- v1: Initial revision\nCREATE TABLE foo (id INTEGER PRIMARY KEY, data TEXT);\n")},
+		"02-data.sql": {Data: []byte("-- v2: Migrate data\n-- transaction: on\n-- run: insertData\n")},
+	}
+	var hookCalled bool
+	hooks := UpgradeHooks{
+		"insertData": func(ctx context.Context, db *Database) error {
+			hookCalled = true
+			_, err := db.Exec(ctx, "INSERT INTO foo (data) VALUES ('meow')")
+			return err
+		},
+	}
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.UpgradeTable = BuildUpgradeTable().WithFSAndHooks(upgrades, hooks).Finish()
+	require.Equal(t, []string{"insertData"}, db.UpgradeTable[1].hooks)
+	ctx := context.Background()
+	require.NoError(t, db.Upgrade(ctx))
+	require.True(t, hookCalled)
+	var data string
+	require.NoError(t, db.QueryRow(ctx, "SELECT data FROM foo").Scan(&data))
+	assert.Equal(t, "meow", data)
+
+	assert.PanicsWithError(t, `dbutil: upgrade 02-data.sql references unknown hook "insertData"`, func() {
+		BuildUpgradeTable().WithFS(upgrades)
+	})
+	hooks["unused"] = hooks["insertData"]
+	assert.PanicsWithError(t, `dbutil: upgrade hooks ["unused"] aren't referenced by any upgrade file`, func() {
+		BuildUpgradeTable().WithFSAndHooks(upgrades, hooks)
+	})
+}
+
+func TestWIPUpgradeTable_HookOnlyFile(t *testing.T) {
+	upgrades := fstest.MapFS{
+		"01-init.sql":          {Data: []byte("-- v1: Initial revision\nCREATE TABLE foo (id INTEGER PRIMARY KEY, data TEXT);\n")},
+		"02-data.sql":          {Data: []byte("-- v2: Migrate data\n-- run: insertData")},
+		"03-more.postgres.sql": {Data: []byte("-- v3: Migrate more data\n-- run: insertMore")},
+		"03-more.sqlite.sql":   {Data: []byte("-- v3: Migrate more data\n-- run: insertMore")},
+	}
+	insert := func(ctx context.Context, db *Database) error {
+		_, err := db.Exec(ctx, "INSERT INTO foo (data) VALUES ('meow')")
+		return err
+	}
+	db, err := NewWithDialect(":memory:", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	db.UpgradeTable = BuildUpgradeTable().WithFSAndHooks(upgrades, UpgradeHooks{"insertData": insert, "insertMore": insert}).Finish()
+	ctx := context.Background()
+	plan, err := db.PlanUpgrade(ctx)
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	require.NoError(t, db.Upgrade(ctx))
+	var count int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM foo").Scan(&count))
+	assert.Equal(t, 2, count)
+}
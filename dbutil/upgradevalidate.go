@@ -0,0 +1,192 @@
+// Copyright (c) 2026 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dbutil
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnreachableUpgrade    = errors.New("latest version is unreachable from upgrade")
+	ErrUpgradeGap            = errors.New("gap in upgrade path")
+	ErrInvalidUpgradeVersion = errors.New("invalid upgrade version")
+	ErrSplitUpgradeMismatch  = errors.New("postgres and sqlite versions of upgrade don't match")
+	ErrInvalidDialectFilter  = errors.New("invalid dialect filter")
+)
+
+func (u *Upgrade) describe() string {
+	name := fmt.Sprintf("v%d -> v%d (%q)", u.from, u.upgradesTo, u.message)
+	if u.fileName != "" {
+		name += " in " + u.fileName
+	}
+	return name
+}
+
+// Validate checks the upgrade table for mistakes that would otherwise only be noticed when
+// upgrading a database, or not at all. It's meant to be called in a unit test, e.g.
+//
+//	func TestUpgradeTable(t *testing.T) {
+//		require.NoError(t, upgradeTable.Validate())
+//	}
+//
+// The returned error contains all problems that were found, each wrapping one of
+// [ErrUnreachableUpgrade], [ErrUpgradeGap], [ErrInvalidUpgradeVersion], [ErrSplitUpgradeMismatch]
+// or [ErrInvalidDialectFilter].
+func (ut UpgradeTable) Validate() error {
+	var errs []error
+	reachable := map[int]struct{}{0: {}}
+	queue := []int{0}
+	for len(queue) > 0 {
+		version := queue[0]
+		queue = queue[1:]
+		var next []int
+		if version < len(ut) && ut[version].fn != nil {
+			next = append(next, ut[version].upgradesTo)
+		}
+		for _, upg := range ut {
+			if upg.downgrade != nil && upg.downgrade.from == version {
+				next = append(next, upg.downgrade.upgradesTo)
+			}
+		}
+		for _, target := range next {
+			if _, ok := reachable[target]; !ok && target >= 0 {
+				reachable[target] = struct{}{}
+				queue = append(queue, target)
+			}
+		}
+	}
+	for _, version := range slices.Sorted(maps.Keys(reachable)) {
+		if version > len(ut) {
+			errs = append(errs, fmt.Errorf("%w: v%d is reachable, but the latest known version is v%d", ErrUpgradeGap, version, len(ut)))
+		} else if version < len(ut) && ut[version].fn == nil {
+			errs = append(errs, fmt.Errorf("%w: v%d is reachable, but there's no upgrade from it", ErrUpgradeGap, version))
+		}
+	}
+
+	for version := range ut {
+		upg := &ut[version]
+		if upg.fn != nil {
+			if !ut.reachesLatest(version) {
+				errs = append(errs, fmt.Errorf("%w %s", ErrUnreachableUpgrade, upg.describe()))
+			}
+			errs = append(errs, upg.validate(false)...)
+		}
+		if upg.downgrade != nil {
+			errs = append(errs, upg.downgrade.validate(true)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reachesLatest checks whether following upgrades from the given version ends at the latest version.
+//
+// Upgrades from versions other than v0 are for existing databases (e.g. step upgrades next to a jump
+// from v0 to the latest version), so they're only checked for leading somewhere, not for being reachable from v0.
+func (ut UpgradeTable) reachesLatest(version int) bool {
+	visited := make(map[int]struct{})
+	for version >= 0 && version < len(ut) && ut[version].fn != nil {
+		if _, loop := visited[version]; loop {
+			return false
+		}
+		visited[version] = struct{}{}
+		version = ut[version].upgradesTo
+	}
+	return version == len(ut)
+}
+
+func (u *Upgrade) validate(isDowngrade bool) (errs []error) {
+	if !isDowngrade && u.upgradesTo <= u.from {
+		errs = append(errs, fmt.Errorf("%w: upgrade %s doesn't go forward", ErrInvalidUpgradeVersion, u.describe()))
+	}
+	if u.compatVersion > u.upgradesTo {
+		errs = append(errs, fmt.Errorf("%w: compat version v%d of %s is higher than the target version", ErrInvalidUpgradeVersion, u.compatVersion, u.describe()))
+	}
+	if u.sql == nil {
+		return
+	}
+	rendered := make(map[Dialect]string, 2)
+	for _, dialect := range []Dialect{Postgres, SQLite} {
+		query, _, err := u.sql(&Database{Dialect: dialect})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w in %s for %s: %w", ErrInvalidDialectFilter, u.describe(), dialect, err))
+			continue
+		}
+		rendered[dialect] = query
+		if !u.split {
+			for i, line := range strings.Split(query, "\n") {
+				if leftoverFilterRegex.MatchString(line) {
+					errs = append(errs, fmt.Errorf("%w in %s: unrecognized filter %q on line %d of %s output", ErrInvalidDialectFilter, u.describe(), strings.TrimSpace(line), i+1, dialect))
+				}
+			}
+		}
+	}
+	if u.split && len(rendered) == 2 {
+		pgTables := tablesModifiedBy(rendered[Postgres])
+		sqliteTables := tablesModifiedBy(rendered[SQLite])
+		if !slices.Equal(pgTables, sqliteTables) {
+			errs = append(errs, fmt.Errorf(
+				"%w: %s modifies tables %v on postgres, but %v on sqlite",
+				ErrSplitUpgradeMismatch, u.describe(), pgTables, sqliteTables,
+			))
+		}
+	}
+	return
+}
+
+// leftoverFilterRegex matches dialect filter lines that filterSQLUpgrade didn't consume (e.g. due to typos).
+var leftoverFilterRegex = regexp.MustCompile(`^\s*--\s*(?:only:|end only)`)
+
+var tableStatementRegex = regexp.MustCompile(
+	`(?im)^\s*(CREATE|DROP|ALTER)\s+(?:TEMP\s+|TEMPORARY\s+)?TABLE\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?"?([\w.]+)"?(?:\s+RENAME\s+TO\s+"?([\w.]+)"?)?`,
+)
+
+// tablesModifiedBy returns the sorted names of tables that the given SQL creates, drops or alters.
+//
+// Tables that are created (or renamed to) and then dropped or renamed within the same SQL, like in
+// SQLite table rebuilds, are treated as temporary and not included. This means that rebuilding
+// a table on SQLite is considered equivalent to altering it on Postgres.
+func tablesModifiedBy(query string) []string {
+	modified := make(map[string]struct{})
+	created := make(map[string]struct{})
+	for _, match := range tableStatementRegex.FindAllStringSubmatch(query, -1) {
+		table := strings.ToLower(match[2])
+		_, isTemporary := created[table]
+		switch strings.ToUpper(match[1]) {
+		case "CREATE":
+			created[table] = struct{}{}
+			modified[table] = struct{}{}
+		case "DROP":
+			if isTemporary {
+				delete(modified, table)
+				delete(created, table)
+			} else {
+				modified[table] = struct{}{}
+			}
+		case "ALTER":
+			if match[3] == "" {
+				modified[table] = struct{}{}
+				continue
+			}
+			newName := strings.ToLower(match[3])
+			if isTemporary {
+				delete(modified, table)
+				delete(created, table)
+			} else {
+				modified[table] = struct{}{}
+			}
+			// Tables renamed to a new name are also temporary if they're dropped later
+			created[newName] = struct{}{}
+			modified[newName] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(modified))
+}
@@ -0,0 +1,102 @@
+package dbutil
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noopUpgrade(ctx context.Context, db *Database) error {
+	return nil
+}
+
+func TestUpgradeTable_Validate_Samples(t *testing.T) {
+	require.NoError(t, makeTable().Validate())
+}
+
+func TestUpgradeTable_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    UpgradeTable
+		expected []error
+	}{
+		{"Valid", BuildUpgradeTable().
+			WithRaw(0, 2, 0, "Jump", TxnModeOn, noopUpgrade).
+			WithRaw(2, 3, 0, "Step", TxnModeOn, noopUpgrade).
+			Finish(), nil},
+		{"Jump with steps", BuildUpgradeTable().
+			WithRaw(0, 3, 0, "Latest revision", TxnModeOn, noopUpgrade).
+			WithRaw(1, 2, 0, "Step A", TxnModeOn, noopUpgrade).
+			WithRaw(2, 3, 0, "Step B", TxnModeOn, noopUpgrade).
+			Finish(), nil},
+		{"Dead end step", BuildUpgradeTable().
+			WithRaw(0, 3, 0, "Latest revision", TxnModeOn, noopUpgrade).
+			WithRaw(1, 2, 0, "Step A", TxnModeOn, noopUpgrade).
+			Finish(), []error{ErrUnreachableUpgrade}},
+		{"Gap", BuildUpgradeTable().
+			WithRaw(0, 1, 0, "First", TxnModeOn, noopUpgrade).
+			WithRaw(2, 3, 0, "Third", TxnModeOn, noopUpgrade).
+			Finish(), []error{ErrUpgradeGap, ErrUnreachableUpgrade}},
+		{"Past latest", BuildUpgradeTable().
+			WithRaw(0, 5, 0, "Jump", TxnModeOn, noopUpgrade).
+			Finish(), []error{ErrUpgradeGap}},
+		{"Compat higher than target", BuildUpgradeTable().
+			WithRaw(0, 1, 2, "Weird compat", TxnModeOn, noopUpgrade).
+			Finish(), []error{ErrInvalidUpgradeVersion}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.table.Validate()
+			if test.expected == nil {
+				assert.NoError(t, err)
+			}
+			for _, expected := range test.expected {
+				assert.ErrorIs(t, err, expected)
+			}
+		})
+	}
+}
+
+func TestUpgradeTable_Validate_Files(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		expected error
+	}{
+		{"Split rebuild is fine", fstest.MapFS{
+			"01-init.sql":           {Data: []byte("-- v1: Init\nCREATE TABLE foo (id INTEGER);")},
+			"02-alter.postgres.sql": {Data: []byte("-- v2: Alter\nALTER TABLE foo ADD COLUMN bar TEXT;")},
+			"02-alter.sqlite.sql": {Data: []byte("-- v2: Alter\n" +
+				"CREATE TABLE foo_new (id INTEGER, bar TEXT);\n" +
+				"INSERT INTO foo_new SELECT id, NULL FROM foo;\n" +
+				"DROP TABLE foo;\n" +
+				"ALTER TABLE foo_new RENAME TO foo;")},
+		}, nil},
+		{"Split mismatch", fstest.MapFS{
+			"01-init.postgres.sql": {Data: []byte("-- v1: Init\nCREATE TABLE foo (id BIGINT);\nCREATE TABLE bar (id BIGINT);")},
+			"01-init.sqlite.sql":   {Data: []byte("-- v1: Init\nCREATE TABLE foo (id INTEGER);")},
+		}, ErrSplitUpgradeMismatch},
+		{"Filter past end of file", fstest.MapFS{
+			"01-init.sql": {Data: []byte("-- v1: Init\nCREATE TABLE foo (id INTEGER);\n-- only: postgres for next 3 lines\nSELECT 1;")},
+		}, ErrInvalidDialectFilter},
+		{"Unterminated fence", fstest.MapFS{
+			"01-init.sql": {Data: []byte("-- v1: Init\n-- only: sqlite until \"end only\"\nCREATE TABLE foo (id INTEGER);")},
+		}, ErrInvalidDialectFilter},
+		{"Typo in filter", fstest.MapFS{
+			"01-init.sql": {Data: []byte("-- v1: Init\n-- only: sqlit\nCREATE TABLE foo (id INTEGER);")},
+		}, ErrInvalidDialectFilter},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := BuildUpgradeTable().WithFS(test.files).Finish().Validate()
+			if test.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.expected)
+			}
+		})
+	}
+}